@@ -1,11 +1,15 @@
 package camera
 
 import (
-	"crypto/tls"
+	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/http/cookiejar"
+	"strings"
+	"time"
 
 	"github.com/stone/timelapser/internal/config"
 	"github.com/stone/timelapser/internal/utils"
@@ -16,47 +20,231 @@ type HTTPClient interface {
 	Do(req *http.Request) (*http.Response, error)
 }
 
+func init() {
+	Register("http", newHTTPSource)
+}
+
+func newHTTPSource(cfg config.CameraConfig) (Source, error) {
+	return NewCamera(cfg)
+}
+
+// Camera fetches snapshots from an HTTP snapshot URL.
 type Camera struct {
 	Config config.CameraConfig
 	Client HTTPClient
+
+	digest *digestAuth   // cached challenge for digest auth, set by NewCamera
+	login  *loginSession // session for login auth, set by NewCamera
+	oauth2 *oauth2Token  // cached access token for oauth2 auth, set by NewCamera
+
+	validators validators // ETag and Last-Modified of the last stored snapshot
+}
+
+func NewCamera(cfg config.CameraConfig) (*Camera, error) {
+	if cfg.Auth.Type == "login" && cfg.Auth.Login.URL == "" {
+		return nil, errLoginNotConfigured
+	}
+	if cfg.Auth.Type == "oauth2" && (cfg.Auth.OAuth2.TokenURL == "" || cfg.Auth.OAuth2.ClientID == "") {
+		return nil, errOAuth2NotConfigured
+	}
+
+	transport, err := sharedTransport(cfg)
+	if err != nil {
+		return nil, err
+	}
+	client := &http.Client{Transport: limitedTransport{next: transport}}
+	cam := &Camera{Config: cfg, Client: client}
+	switch cfg.Auth.Type {
+	case "digest":
+		cam.digest = newDigestAuth(cfg.Auth.Username, cfg.Auth.Password)
+	case "login":
+		// cookiejar.New only fails on a misconfigured public suffix list.
+		jar, _ := cookiejar.New(nil)
+		client.Jar = jar
+		cam.login = newLoginSession(cfg.Auth, jar)
+	case "oauth2":
+		cam.oauth2 = newOAuth2Token(cfg.Auth.OAuth2)
+	}
+	return cam, nil
 }
 
-func NewCamera(cfg config.CameraConfig) *Camera {
-	client := &http.Client{}
-	if cfg.Insecure {
-		client = &http.Client{
-			Transport: &http.Transport{
-				TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-			},
+// Fetch retrieves a snapshot, retrying transient failures with exponential
+// backoff. Each attempt is bounded by the configured timeout.
+func (c *Camera) Fetch(ctx context.Context) (*Frame, error) {
+	for attempt := 0; ; attempt++ {
+		frame, err := c.fetchOnce(ctx)
+		if err == nil {
+			return frame, nil
+		}
+		if attempt >= c.Config.Retries || !isTransient(err) || ctx.Err() != nil {
+			if attempt > 0 {
+				return nil, fmt.Errorf("%w (after %d attempts)", err, attempt+1)
+			}
+			return nil, err
+		}
+		if sleepErr := sleepContext(ctx, retryDelay(c.Config.RetryBackoff, attempt)); sleepErr != nil {
+			return nil, err
 		}
 	}
-	return &Camera{Config: cfg, Client: client}
 }
 
-func (c *Camera) GetSnapshot() ([]byte, error) {
-	req, err := c.prepareRequest()
-	if err != nil {
-		return nil, fmt.Errorf("error preparing request: %v", err)
+func (c *Camera) fetchOnce(ctx context.Context) (*Frame, error) {
+	var cancel context.CancelFunc
+	if c.Config.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, c.Config.Timeout)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
 	}
+	defer cancel()
 
-	resp, err := c.Client.Do(req)
+	resp, err := c.do(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("error making request: %v", err)
+		return nil, err
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusNotModified {
+		return nil, ErrNotModified
+	}
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		return nil, &StatusError{Code: resp.StatusCode}
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+
+	// An MJPEG stream never ends, so only the first frame is read and the
+	// connection is dropped when the deferred cancel runs.
+	if isMJPEG(contentType) {
+		timer := time.AfterFunc(mjpegTimeout(c.Config.MJPEG), cancel)
+		defer timer.Stop()
+
+		frame, err := readMJPEGFrame(ctx, resp.Body, contentType, mjpegMaxFrameSize(c.Config))
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, fmt.Errorf("timed out waiting for mjpeg frame: %v", err)
+			}
+			return nil, fmt.Errorf("error reading mjpeg stream: %w", err)
+		}
+		frame.Time = time.Now()
+		return frame, nil
+	}
+	if c.Config.Type == "mjpeg" {
+		return nil, fmt.Errorf("expected %s response, got %q", mjpegContentType, contentType)
+	}
+
+	limit := maxSize(c.Config)
+	if resp.ContentLength > limit {
+		return nil, fmt.Errorf("%w, content length %d, content type %q", tooLarge(limit), resp.ContentLength, contentType)
+	}
+	frame, err := spool(ctx, resp.Body, limit)
+	if errors.Is(err, ErrTooLarge) {
+		return nil, fmt.Errorf("%w, content type %q", err, contentType)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading response: %w", err)
+	}
+	frame.ContentType = contentType
+	frame.Time = time.Now()
+	frame.conditions = responseConditions(resp)
+	return frame, nil
+}
+
+// Ack makes the next request conditional on the ETag and Last-Modified of
+// frame, now that it was stored or skipped as a duplicate.
+func (c *Camera) Ack(frame *Frame) error {
+	c.validators.update(frame.conditions)
+	return nil
+}
+
+// do sends the snapshot request. When the camera rejects the credentials
+// they are refreshed (new digest challenge, login session or access token) and
+// the request is retried once.
+func (c *Camera) do(ctx context.Context) (*http.Response, error) {
+	if c.login != nil && !c.login.active() {
+		if err := c.login.login(ctx, c.Client); err != nil {
+			return nil, fmt.Errorf("login failed: %v", err)
+		}
+	}
+
+	resp, err := c.send(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	retry, err := c.reauthenticate(ctx, resp)
+	if err != nil {
+		return nil, err
+	}
+	if !retry {
+		return resp, nil
+	}
+	return c.send(ctx)
+}
+
+func (c *Camera) send(ctx context.Context) (*http.Response, error) {
+	req, err := c.prepareRequest(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error preparing request: %v", err)
+	}
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error making request: %w", err)
 	}
+	return resp, nil
+}
 
-	return io.ReadAll(resp.Body)
+// reauthenticate refreshes the credentials if resp shows they were rejected
+// and reports whether the request should be retried. The response body is
+// closed when a retry is requested.
+func (c *Camera) reauthenticate(ctx context.Context, resp *http.Response) (bool, error) {
+	switch {
+	case c.digest != nil && resp.StatusCode == http.StatusUnauthorized:
+		// No nonce cached yet, or the cached one went stale.
+		resp.Body.Close()
+		if err := c.digest.setChallenge(resp.Header.Values("WWW-Authenticate")); err != nil {
+			return false, fmt.Errorf("digest authentication failed: %v", err)
+		}
+		return true, nil
+
+	case c.oauth2 != nil && resp.StatusCode == http.StatusUnauthorized:
+		// The token was revoked or expired early; fetch a new one.
+		resp.Body.Close()
+		c.oauth2.invalidate()
+		return true, nil
+
+	case c.login != nil && c.login.expired(resp):
+		resp.Body.Close()
+		if err := c.login.login(ctx, c.Client); err != nil {
+			return false, fmt.Errorf("login failed: %v", err)
+		}
+		return true, nil
+	}
+	return false, nil
 }
 
-func (c *Camera) prepareRequest() (*http.Request, error) {
-	req, err := http.NewRequest(http.MethodGet, c.Config.SnapshotURL, nil)
+func (c *Camera) prepareRequest(ctx context.Context) (*http.Request, error) {
+	method := c.Config.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	var body io.Reader
+	if c.Config.Body != "" {
+		body = strings.NewReader(c.Config.Body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.Config.SnapshotURL, body)
 	if err != nil {
 		return nil, err
 	}
+	for name, value := range c.Config.Headers {
+		req.Header.Set(name, value)
+	}
+	// Conditional requests only make sense for reads; a POST usually
+	// triggers a fresh capture.
+	if method == http.MethodGet {
+		c.validators.apply(req)
+	}
 
 	// Apply authentication
 	switch c.Config.Auth.Type {
@@ -69,6 +257,26 @@ func (c *Camera) prepareRequest() (*http.Request, error) {
 
 	case "bearer":
 		req.Header.Add("Authorization", "Bearer "+c.Config.Auth.Token)
+
+	case "oauth2":
+		if c.oauth2 != nil {
+			token, err := c.oauth2.token(req.Context(), c.Client)
+			if err != nil {
+				return nil, fmt.Errorf("oauth2: %w", err)
+			}
+			req.Header.Add("Authorization", "Bearer "+token)
+		}
+
+	case "digest":
+		// Without a cached challenge the request goes out unauthenticated
+		// and the 401 response provides the nonce.
+		if c.digest != nil && c.digest.hasChallenge() {
+			auth, err := c.digest.authorization(req.Method, req.URL.RequestURI())
+			if err != nil {
+				return nil, err
+			}
+			req.Header.Add("Authorization", auth)
+		}
 	}
 
 	return req, nil
@@ -2,8 +2,13 @@ package camera
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"io"
 	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
@@ -19,7 +24,16 @@ func (m *mockHTTPClient) Do(req *http.Request) (*http.Response, error) {
 	return m.doFunc(req)
 }
 
-func TestCamera_getSnapshot(t *testing.T) {
+func mustNewCamera(t *testing.T, cfg config.CameraConfig) *Camera {
+	t.Helper()
+	camera, err := NewCamera(cfg)
+	if err != nil {
+		t.Fatalf("NewCamera() error = %v", err)
+	}
+	return camera
+}
+
+func TestCamera_fetch(t *testing.T) {
 	tests := []struct {
 		name          string
 		config        config.CameraConfig
@@ -58,6 +72,33 @@ func TestCamera_getSnapshot(t *testing.T) {
 			},
 			expectedError: "unexpected status code: 401",
 		},
+		{
+			name: "content length over max size",
+			config: config.CameraConfig{
+				SnapshotURL: "http://example.com/video.mp4",
+				MaxSize:     8,
+			},
+			mockResponse: &http.Response{
+				StatusCode:    http.StatusOK,
+				Header:        http.Header{"Content-Type": {"video/mp4"}},
+				ContentLength: 1 << 30,
+				Body:          io.NopCloser(strings.NewReader("")),
+			},
+			expectedError: `snapshot too large: more than 8 bytes (maxSize), content length 1073741824, content type "video/mp4"`,
+		},
+		{
+			name: "body over max size",
+			config: config.CameraConfig{
+				SnapshotURL: "http://example.com/snapshot",
+				MaxSize:     8,
+			},
+			mockResponse: &http.Response{
+				StatusCode:    http.StatusOK,
+				ContentLength: -1,
+				Body:          io.NopCloser(strings.NewReader("image data")),
+			},
+			expectedError: "snapshot too large: more than 8 bytes (maxSize)",
+		},
 	}
 
 	for _, tt := range tests {
@@ -71,7 +112,7 @@ func TestCamera_getSnapshot(t *testing.T) {
 				},
 			}
 
-			data, err := camera.GetSnapshot()
+			frame, err := camera.Fetch(context.Background())
 
 			// Check error cases
 			if tt.expectedError != "" {
@@ -90,9 +131,158 @@ func TestCamera_getSnapshot(t *testing.T) {
 				t.Errorf("unexpected error: %v", err)
 				return
 			}
+			data := frameData(t, frame)
 			if !bytes.Equal(data, tt.expectedData) {
 				t.Errorf("data = %v, want %v", data, tt.expectedData)
 			}
 		})
 	}
 }
+
+func TestCamera_fetchSpoolsToFile(t *testing.T) {
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+	body := "image data"
+	camera := &Camera{
+		Config: config.CameraConfig{SnapshotURL: "http://example.com/snapshot", MaxSize: 8},
+		Client: &mockHTTPClient{
+			doFunc: func(*http.Request) (*http.Response, error) {
+				return &http.Response{StatusCode: http.StatusOK, ContentLength: -1, Body: io.NopCloser(strings.NewReader(body))}, nil
+			},
+		},
+	}
+
+	// An oversized body is aborted and its temp file removed.
+	if _, err := camera.Fetch(context.Background()); !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("error = %v, want ErrTooLarge", err)
+	}
+	if entries, _ := os.ReadDir(tmp); len(entries) != 0 {
+		t.Errorf("temp files left behind: %v", entries)
+	}
+
+	camera.Config.MaxSize = int64(len(body))
+	frame, err := camera.Fetch(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if frame.Data != nil {
+		t.Errorf("frame held in memory, want it in a temp file")
+	}
+	if data := frameData(t, frame); string(data) != body {
+		t.Errorf("data = %q, want %q", data, body)
+	}
+	if err := frame.Close(); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+	if entries, _ := os.ReadDir(tmp); len(entries) != 0 {
+		t.Errorf("temp files left after Close: %v", entries)
+	}
+
+	spoolDir := t.TempDir()
+	frame, err = camera.Fetch(WithSpoolDir(context.Background(), spoolDir))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer frame.Close()
+	if file, ok := frame.File(); !ok || filepath.Dir(file) != spoolDir {
+		t.Errorf("File() = %q, %v, want a file in %s", file, ok, spoolDir)
+	}
+}
+
+func TestCamera_fetchRequestOptions(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Method != http.MethodPost ||
+			r.Header.Get("X-API-Key") != "s3cr$t" ||
+			r.Header.Get("Content-Type") != "application/json" ||
+			string(body) != `{"action":"capture"}` {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte("image data"))
+	}))
+	defer server.Close()
+
+	camera := mustNewCamera(t, config.CameraConfig{
+		SnapshotURL: server.URL,
+		Method:      http.MethodPost,
+		Headers: map[string]string{
+			"X-API-Key":    "s3cr$t",
+			"Content-Type": "application/json",
+		},
+		Body:    `{"action":"capture"}`,
+		Retries: 1,
+	})
+
+	frame, err := camera.Fetch(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data := frameData(t, frame)
+	if string(data) != "image data" {
+		t.Errorf("data = %q, want %q", data, "image data")
+	}
+}
+
+func TestCamera_fetchProxy(t *testing.T) {
+	var proxied string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// A forward proxy receives the absolute target URL.
+		proxied = r.URL.String()
+		w.Write([]byte("image via proxy"))
+	}))
+	defer proxy.Close()
+
+	camera := mustNewCamera(t, config.CameraConfig{
+		SnapshotURL: "http://camera.example.invalid/snapshot.jpg",
+		Proxy:       proxy.URL,
+	})
+
+	frame, err := camera.Fetch(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if proxied != "http://camera.example.invalid/snapshot.jpg" {
+		t.Errorf("proxied url = %q", proxied)
+	}
+	data := frameData(t, frame)
+	if string(data) != "image via proxy" {
+		t.Errorf("data = %q, want %q", data, "image via proxy")
+	}
+}
+
+func TestNewCamera_invalidProxy(t *testing.T) {
+	tests := []struct {
+		name          string
+		config        config.CameraConfig
+		expectedError string
+	}{
+		{
+			name:          "unsupported proxy",
+			config:        config.CameraConfig{Proxy: "ftp://proxy:21"},
+			expectedError: `unsupported proxy scheme "ftp"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewCamera(tt.config)
+			if err == nil || !strings.Contains(err.Error(), tt.expectedError) {
+				t.Errorf("error = %v, want %v", err, tt.expectedError)
+			}
+		})
+	}
+}
+
+// frameData returns the image of frame and removes its temporary file when
+// the test ends.
+func frameData(t *testing.T, frame *Frame) []byte {
+	t.Helper()
+	t.Cleanup(func() { frame.Close() })
+	r, size := frame.Reader()
+	data := make([]byte, size)
+	if _, err := io.ReadFull(io.NewSectionReader(r, 0, size), data); err != nil {
+		t.Fatalf("reading frame: %v", err)
+	}
+	return data
+}
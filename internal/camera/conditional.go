@@ -0,0 +1,45 @@
+package camera
+
+import (
+	"net/http"
+	"sync"
+)
+
+// validators remembers the ETag and Last-Modified of the last stored snapshot
+// so the next request can be made conditional. A camera that has no new image
+// then answers 304 Not Modified without sending the image again.
+type validators struct {
+	mu      sync.Mutex
+	current conditions
+}
+
+// conditions are the ETag and Last-Modified of a response. They are kept on
+// the Frame until it is acknowledged, so that a frame that is rejected or
+// fails to store is fetched again instead of being answered with 304.
+type conditions struct {
+	etag         string
+	lastModified string
+}
+
+func responseConditions(resp *http.Response) conditions {
+	return conditions{etag: resp.Header.Get("ETag"), lastModified: resp.Header.Get("Last-Modified")}
+}
+
+// apply adds If-None-Match and If-Modified-Since headers to req.
+func (v *validators) apply(req *http.Request) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.current.etag != "" {
+		req.Header.Set("If-None-Match", v.current.etag)
+	}
+	if v.current.lastModified != "" {
+		req.Header.Set("If-Modified-Since", v.current.lastModified)
+	}
+}
+
+// update stores the conditions of a handled snapshot.
+func (v *validators) update(c conditions) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.current = c
+}
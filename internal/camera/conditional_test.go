@@ -0,0 +1,79 @@
+package camera
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stone/timelapser/internal/config"
+)
+
+func TestCamera_fetchConditional(t *testing.T) {
+	modified := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	var (
+		version atomic.Int32
+		lastINM atomic.Value
+		lastIMS atomic.Value
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lastINM.Store(r.Header.Get("If-None-Match"))
+		lastIMS.Store(r.Header.Get("If-Modified-Since"))
+		v := version.Load()
+		w.Header().Set("ETag", fmt.Sprintf(`"v%d"`, v))
+		// ServeContent answers conditional requests with 304.
+		http.ServeContent(w, r, "snapshot.jpg", modified.Add(time.Duration(v)*time.Minute), bytes.NewReader([]byte{byte(v)}))
+	}))
+	defer server.Close()
+
+	camera := mustNewCamera(t, config.CameraConfig{SnapshotURL: server.URL})
+
+	frame, err := camera.Fetch(context.Background())
+	if err != nil {
+		t.Fatalf("first fetch: %v", err)
+	}
+	if got := lastINM.Load(); got != "" {
+		t.Errorf("first request If-None-Match = %q, want none", got)
+	}
+
+	// Until the frame is acknowledged, e.g. because it was rejected, the
+	// image is requested again.
+	if _, err := camera.Fetch(context.Background()); err != nil {
+		t.Fatalf("unacknowledged frame: %v", err)
+	}
+	if got := lastINM.Load(); got != "" {
+		t.Errorf("unacknowledged frame: If-None-Match = %q, want none", got)
+	}
+
+	camera.Ack(frame)
+	_, err = camera.Fetch(context.Background())
+	if !errors.Is(err, ErrNotModified) {
+		t.Fatalf("unchanged image: error = %v, want ErrNotModified", err)
+	}
+	if got := lastINM.Load(); got != `"v0"` {
+		t.Errorf("If-None-Match = %q, want %q", got, `"v0"`)
+	}
+	if got := lastIMS.Load(); got != modified.Format(http.TimeFormat) {
+		t.Errorf("If-Modified-Since = %q, want %q", got, modified.Format(http.TimeFormat))
+	}
+
+	version.Store(1)
+	frame, err = camera.Fetch(context.Background())
+	if err != nil {
+		t.Fatalf("changed image: %v", err)
+	}
+	data := frameData(t, frame)
+	if !bytes.Equal(data, []byte{1}) {
+		t.Errorf("data = %v, want [1]", data)
+	}
+
+	// A 304 must not count as a failure worth retrying.
+	if isTransient(ErrNotModified) {
+		t.Error("ErrNotModified must not be retried")
+	}
+}
@@ -0,0 +1,207 @@
+package camera
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"strings"
+	"sync"
+)
+
+// digestChallenge holds the parameters of a WWW-Authenticate: Digest header.
+type digestChallenge struct {
+	realm     string
+	nonce     string
+	opaque    string
+	algorithm string // MD5, MD5-sess, SHA-256 or SHA-256-sess
+	qop       string // auth or empty for RFC 2069 style challenges
+}
+
+// digestAuth implements RFC 7616 Digest authentication. The last challenge is
+// kept so later snapshots can authenticate up front instead of paying for a
+// 401 round trip every time.
+type digestAuth struct {
+	username string
+	password string
+
+	mu        sync.Mutex
+	challenge *digestChallenge
+	nc        uint32
+}
+
+func newDigestAuth(username, password string) *digestAuth {
+	return &digestAuth{username: username, password: password}
+}
+
+// hasChallenge reports whether a nonce has been cached.
+func (d *digestAuth) hasChallenge() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.challenge != nil
+}
+
+// setChallenge parses the WWW-Authenticate headers of a 401 response and
+// caches the strongest supported Digest challenge.
+func (d *digestAuth) setChallenge(headers []string) error {
+	var best *digestChallenge
+	for _, header := range headers {
+		c, err := parseDigestChallenge(header)
+		if err != nil {
+			continue
+		}
+		if best == nil || (strings.HasPrefix(c.algorithm, "SHA-256") && !strings.HasPrefix(best.algorithm, "SHA-256")) {
+			best = c
+		}
+	}
+	if best == nil {
+		return errors.New("no supported digest challenge in response")
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.challenge = best
+	d.nc = 0
+	return nil
+}
+
+// authorization returns the Authorization header value for a request.
+func (d *digestAuth) authorization(method, uri string) (string, error) {
+	d.mu.Lock()
+	c := d.challenge
+	d.nc++
+	nc := fmt.Sprintf("%08x", d.nc)
+	d.mu.Unlock()
+
+	if c == nil {
+		return "", errors.New("no digest challenge received")
+	}
+
+	cnonce, err := newCnonce()
+	if err != nil {
+		return "", err
+	}
+
+	newHash := md5.New
+	if strings.HasPrefix(c.algorithm, "SHA-256") {
+		newHash = sha256.New
+	}
+	h := func(s string) string {
+		return hashHex(newHash(), s)
+	}
+
+	ha1 := h(d.username + ":" + c.realm + ":" + d.password)
+	if strings.HasSuffix(c.algorithm, "-sess") {
+		ha1 = h(ha1 + ":" + c.nonce + ":" + cnonce)
+	}
+	ha2 := h(method + ":" + uri)
+
+	var response string
+	if c.qop == "" {
+		response = h(ha1 + ":" + c.nonce + ":" + ha2)
+	} else {
+		response = h(ha1 + ":" + c.nonce + ":" + nc + ":" + cnonce + ":" + c.qop + ":" + ha2)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `Digest username="%s", realm="%s", nonce="%s", uri="%s", algorithm=%s, response="%s"`,
+		d.username, c.realm, c.nonce, uri, c.algorithm, response)
+	if c.qop != "" {
+		fmt.Fprintf(&b, `, qop=%s, nc=%s, cnonce="%s"`, c.qop, nc, cnonce)
+	}
+	if c.opaque != "" {
+		fmt.Fprintf(&b, `, opaque="%s"`, c.opaque)
+	}
+	return b.String(), nil
+}
+
+// parseDigestChallenge parses a single WWW-Authenticate header value.
+func parseDigestChallenge(header string) (*digestChallenge, error) {
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(header), " ")
+	if !strings.EqualFold(scheme, "Digest") {
+		return nil, fmt.Errorf("not a digest challenge: %q", scheme)
+	}
+
+	params := parseAuthParams(rest)
+	c := &digestChallenge{
+		realm:     params["realm"],
+		nonce:     params["nonce"],
+		opaque:    params["opaque"],
+		algorithm: strings.ToUpper(params["algorithm"]),
+	}
+	if c.nonce == "" {
+		return nil, errors.New("digest challenge without nonce")
+	}
+
+	switch c.algorithm {
+	case "":
+		c.algorithm = "MD5"
+	case "MD5", "MD5-SESS", "SHA-256", "SHA-256-SESS":
+		c.algorithm = strings.Replace(c.algorithm, "-SESS", "-sess", 1)
+	default:
+		return nil, fmt.Errorf("unsupported digest algorithm %q", c.algorithm)
+	}
+
+	if qop, ok := params["qop"]; ok {
+		for _, q := range strings.Split(qop, ",") {
+			if strings.TrimSpace(q) == "auth" {
+				c.qop = "auth"
+			}
+		}
+		if c.qop == "" {
+			return nil, fmt.Errorf("unsupported digest qop %q", qop)
+		}
+	}
+
+	return c, nil
+}
+
+// parseAuthParams splits a comma-separated list of key=value pairs where
+// values may be quoted strings containing commas.
+func parseAuthParams(s string) map[string]string {
+	params := make(map[string]string)
+	for len(s) > 0 {
+		s = strings.TrimLeft(s, " ,")
+		key, rest, found := strings.Cut(s, "=")
+		if !found {
+			break
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		rest = strings.TrimLeft(rest, " ")
+
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			var b strings.Builder
+			i := 1
+			for ; i < len(rest) && rest[i] != '"'; i++ {
+				if rest[i] == '\\' && i+1 < len(rest) {
+					i++
+				}
+				b.WriteByte(rest[i])
+			}
+			value = b.String()
+			s = rest[min(i+1, len(rest)):]
+		} else {
+			value, s, _ = strings.Cut(rest, ",")
+			value = strings.TrimSpace(value)
+		}
+		params[key] = value
+	}
+	return params
+}
+
+func hashHex(h hash.Hash, s string) string {
+	h.Write([]byte(s))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func newCnonce() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generating cnonce: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
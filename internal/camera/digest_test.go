@@ -0,0 +1,172 @@
+package camera
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stone/timelapser/internal/config"
+)
+
+// digestServer is a minimal RFC 7616 server accepting a single user.
+type digestServer struct {
+	algorithm string
+	nonce     atomic.Value
+	requests  atomic.Int32
+	password  string
+}
+
+func (s *digestServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.requests.Add(1)
+	nonce := s.nonce.Load().(string)
+
+	params := parseAuthParams(strings.TrimPrefix(r.Header.Get("Authorization"), "Digest "))
+	if params["nonce"] != nonce || params["response"] != s.expected(r, params) {
+		stale := params["nonce"] != "" && params["nonce"] != nonce
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(
+			`Digest realm="cam", qop="auth,auth-int", algorithm=%s, nonce="%s", opaque="xyz", stale=%t`,
+			s.algorithm, nonce, stale))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	w.Write([]byte("image data"))
+}
+
+func (s *digestServer) expected(r *http.Request, params map[string]string) string {
+	newHash := md5.New
+	if s.algorithm == "SHA-256" {
+		newHash = sha256.New
+	}
+	h := func(v string) string {
+		return hashHex(newHash(), v)
+	}
+	ha1 := h("admin:cam:" + s.password)
+	ha2 := h(r.Method + ":" + params["uri"])
+	return h(strings.Join([]string{ha1, params["nonce"], params["nc"], params["cnonce"], params["qop"], ha2}, ":"))
+}
+
+func TestCamera_fetchDigest(t *testing.T) {
+	for _, algorithm := range []string{"MD5", "SHA-256"} {
+		t.Run(algorithm, func(t *testing.T) {
+			srv := &digestServer{algorithm: algorithm, password: "secret"}
+			srv.nonce.Store("nonce-1")
+			server := httptest.NewServer(srv)
+			defer server.Close()
+
+			camera := mustNewCamera(t, config.CameraConfig{
+				SnapshotURL: server.URL + "/snapshot?channel=1",
+				Auth:        config.AuthConfig{Type: "digest", Username: "admin", Password: "secret"},
+			})
+
+			// First snapshot needs the 401 round trip to learn the nonce.
+			if _, err := camera.Fetch(context.Background()); err != nil {
+				t.Fatalf("first fetch: %v", err)
+			}
+			if got := srv.requests.Load(); got != 2 {
+				t.Errorf("requests after first fetch = %d, want 2", got)
+			}
+
+			// The cached nonce is reused by the next scheduled snapshot.
+			if _, err := camera.Fetch(context.Background()); err != nil {
+				t.Fatalf("second fetch: %v", err)
+			}
+			if got := srv.requests.Load(); got != 3 {
+				t.Errorf("requests after second fetch = %d, want 3", got)
+			}
+
+			// A rotated nonce triggers one more challenge.
+			srv.nonce.Store("nonce-2")
+			frame, err := camera.Fetch(context.Background())
+			if err != nil {
+				t.Fatalf("fetch after nonce rotation: %v", err)
+			}
+			if got := srv.requests.Load(); got != 5 {
+				t.Errorf("requests after nonce rotation = %d, want 5", got)
+			}
+			data := frameData(t, frame)
+			if string(data) != "image data" {
+				t.Errorf("data = %q, want %q", data, "image data")
+			}
+		})
+	}
+}
+
+func TestCamera_fetchDigestWrongPassword(t *testing.T) {
+	srv := &digestServer{algorithm: "MD5", password: "secret"}
+	srv.nonce.Store("nonce-1")
+	server := httptest.NewServer(srv)
+	defer server.Close()
+
+	camera := mustNewCamera(t, config.CameraConfig{
+		SnapshotURL: server.URL,
+		Auth:        config.AuthConfig{Type: "digest", Username: "admin", Password: "wrong"},
+	})
+
+	_, err := camera.Fetch(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "unexpected status code: 401") {
+		t.Errorf("error = %v, want unexpected status code: 401", err)
+	}
+	if got := srv.requests.Load(); got != 2 {
+		t.Errorf("requests = %d, want 2", got)
+	}
+}
+
+func TestParseDigestChallenge(t *testing.T) {
+	tests := []struct {
+		name          string
+		header        string
+		expected      digestChallenge
+		expectedError string
+	}{
+		{
+			name:     "rfc 2069 style",
+			header:   `Digest realm="cam", nonce="abc"`,
+			expected: digestChallenge{realm: "cam", nonce: "abc", algorithm: "MD5"},
+		},
+		{
+			name:     "quoted commas and qop list",
+			header:   `Digest realm="IP Camera, Inc", qop="auth-int, auth", nonce="n", opaque="o", algorithm=SHA-256-sess`,
+			expected: digestChallenge{realm: "IP Camera, Inc", nonce: "n", opaque: "o", algorithm: "SHA-256-sess", qop: "auth"},
+		},
+		{
+			name:          "basic challenge",
+			header:        `Basic realm="cam"`,
+			expectedError: "not a digest challenge",
+		},
+		{
+			name:          "unsupported algorithm",
+			header:        `Digest realm="cam", nonce="n", algorithm=SHA-512-256`,
+			expectedError: "unsupported digest algorithm",
+		},
+		{
+			name:          "only auth-int",
+			header:        `Digest realm="cam", nonce="n", qop="auth-int"`,
+			expectedError: "unsupported digest qop",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := parseDigestChallenge(tt.header)
+
+			if tt.expectedError != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.expectedError) {
+					t.Errorf("error = %v, want %v", err, tt.expectedError)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if *c != tt.expected {
+				t.Errorf("challenge = %+v, want %+v", *c, tt.expected)
+			}
+		})
+	}
+}
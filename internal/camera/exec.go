@@ -0,0 +1,173 @@
+package camera
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/stone/timelapser/internal/config"
+)
+
+const (
+	// defaultExecTimeout bounds a capture command when no camera timeout is set.
+	defaultExecTimeout = 30 * time.Second
+	// execWaitDelay bounds how long the output of a command is still read
+	// after it exited or was killed, e.g. while a process it started keeps
+	// stdout open.
+	execWaitDelay = 2 * time.Second
+)
+
+func init() {
+	Register("exec", func(cfg config.CameraConfig) (Source, error) {
+		return NewExecCamera(cfg)
+	})
+}
+
+// ExecCamera runs a capture command, e.g. libcamera-still or gphoto2, and
+// uses the image it writes to stdout or to {{.OutputPath}}.
+type ExecCamera struct {
+	Config config.CameraConfig
+	Logger *slog.Logger
+
+	args []*template.Template
+}
+
+// execTemplateData holds the variables available to command arguments.
+type execTemplateData struct {
+	Name       string // camera name as configured
+	OutputPath string // temporary file the command may write the image to
+}
+
+// NewExecCamera parses the command. Like timelapse.executeFFmpeg the command
+// is split on whitespace and run without a shell, but each argument is
+// rendered separately, so values containing spaces or shell metacharacters
+// stay a single argument.
+func NewExecCamera(cfg config.CameraConfig) (*ExecCamera, error) {
+	fields := strings.Fields(cfg.Exec.Command)
+	if len(fields) == 0 {
+		return nil, errors.New("exec camera requires exec.command")
+	}
+
+	args := make([]*template.Template, len(fields))
+	for i, field := range fields {
+		tmpl, err := template.New("exec").Parse(field)
+		if err != nil {
+			return nil, fmt.Errorf("parsing exec command: %w", err)
+		}
+		args[i] = tmpl
+	}
+
+	c := &ExecCamera{Config: cfg, Logger: slog.Default(), args: args}
+	// Catch unknown variables at startup rather than on the first capture.
+	if _, err := c.buildArgs(execTemplateData{}); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *ExecCamera) Fetch(ctx context.Context) (*Frame, error) {
+	outputFile, err := os.CreateTemp("", "timelapser-exec-*")
+	if err != nil {
+		return nil, fmt.Errorf("error creating output file: %w", err)
+	}
+	outputFile.Close()
+	defer os.Remove(outputFile.Name())
+
+	args, err := c.buildArgs(execTemplateData{Name: c.Config.Name, OutputPath: outputFile.Name()})
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := c.Config.Timeout
+	if timeout <= 0 {
+		timeout = defaultExecTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	stdout := &limitedBuffer{limit: maxSize(c.Config)}
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdout = stdout
+	cmd.Stderr = &stderr
+	cmd.WaitDelay = execWaitDelay
+	killProcessGroup(cmd)
+	// Arguments may hold secrets substituted from the environment or files.
+	c.Logger.Debug("Executing capture command", "name", c.Config.Name, "command", cmd.Path, "args", len(args)-1)
+
+	err = cmd.Run()
+	msg := strings.TrimSpace(stderr.String())
+	// The command usually dies of a broken pipe once the output is cut off.
+	if stdout.exceeded {
+		return nil, tooLarge(stdout.limit)
+	}
+	if errors.Is(err, exec.ErrWaitDelay) && ctx.Err() == nil {
+		// The command succeeded, but something it started held on to its
+		// output; what was written until then is used.
+		c.Logger.Warn("Capture command left processes holding its output", "name", c.Config.Name)
+		err = nil
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("capture command timed out after %v", timeout)
+		}
+		return nil, fmt.Errorf("capture command failed: %w (%s)", err, msg)
+	}
+	if msg != "" {
+		c.Logger.Info("Capture command output", "name", c.Config.Name, "stderr", msg)
+	}
+
+	data := stdout.Bytes()
+	if len(data) == 0 {
+		// Tools that cannot write to stdout write to {{.OutputPath}} instead.
+		if data, err = readFileLimited(outputFile.Name(), maxSize(c.Config)); err != nil {
+			return nil, fmt.Errorf("error reading output file: %w", err)
+		}
+	}
+	if len(data) == 0 {
+		return nil, errors.New("capture command produced no image")
+	}
+
+	return &Frame{Data: data, Time: time.Now()}, nil
+}
+
+// buildArgs renders each argument template with data.
+func (c *ExecCamera) buildArgs(data execTemplateData) ([]string, error) {
+	args := make([]string, len(c.args))
+	for i, tmpl := range c.args {
+		var buf strings.Builder
+		if err := tmpl.Execute(&buf, data); err != nil {
+			return nil, fmt.Errorf("executing exec command template: %w", err)
+		}
+		args[i] = buf.String()
+	}
+	return args, nil
+}
+
+// limitedBuffer collects command output up to limit bytes. Writing more
+// fails, which stops copying from the command's stdout. The buffer is not
+// embedded, so io.Copy cannot bypass Write through bytes.Buffer.ReadFrom.
+type limitedBuffer struct {
+	buf      bytes.Buffer
+	limit    int64
+	exceeded bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if int64(b.buf.Len()+len(p)) > b.limit {
+		b.exceeded = true
+		return 0, tooLarge(b.limit)
+	}
+	return b.buf.Write(p)
+}
+
+func (b *limitedBuffer) Bytes() []byte {
+	return b.buf.Bytes()
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package camera
+
+import "os/exec"
+
+// killProcessGroup is a no-op without Unix process groups; cmd.WaitDelay
+// still bounds how long Fetch waits for the command's output.
+func killProcessGroup(cmd *exec.Cmd) {}
@@ -0,0 +1,164 @@
+package camera
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stone/timelapser/internal/config"
+)
+
+func TestExecCamera_buildArgs(t *testing.T) {
+	camera, err := NewExecCamera(config.CameraConfig{
+		Exec: config.ExecConfig{Command: "capture --name {{.Name}} -o {{.OutputPath}}"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	args, err := camera.buildArgs(execTemplateData{Name: "Front door; rm -rf /", OutputPath: "/tmp/out"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []string{"capture", "--name", "Front door; rm -rf /", "-o", "/tmp/out"}
+	if !reflect.DeepEqual(args, expected) {
+		t.Errorf("args = %q, want %q", args, expected)
+	}
+}
+
+func TestExecCamera_Fetch(t *testing.T) {
+	image := filepath.Join(t.TempDir(), "image.jpg")
+	os.WriteFile(image, []byte("image data"), 0o644)
+
+	tests := []struct {
+		name          string
+		command       string
+		timeout       time.Duration
+		maxSize       int64
+		expectedData  string
+		expectedError string
+	}{
+		{name: "stdout", command: "cat " + image, expectedData: "image data"},
+		{name: "output path", command: "cp " + image + " {{.OutputPath}}", expectedData: "image data"},
+		{name: "no output", command: "true", expectedError: "capture command produced no image"},
+		{name: "failure includes stderr", command: "cat /nonexistent/image.jpg", expectedError: "No such file or directory"},
+		{name: "stdout over max size", command: "yes", maxSize: 1024, expectedError: "snapshot too large: more than 1024 bytes"},
+		{name: "output file over max size", command: "cp " + image + " {{.OutputPath}}", maxSize: 4, expectedError: "snapshot too large: more than 4 bytes"},
+		{name: "timeout", command: "sleep 5", timeout: 50 * time.Millisecond, expectedError: "capture command timed out after 50ms"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			camera, err := NewExecCamera(config.CameraConfig{
+				Name:    "test",
+				Timeout: tt.timeout,
+				MaxSize: tt.maxSize,
+				Exec:    config.ExecConfig{Command: tt.command},
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			camera.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+			frame, err := camera.Fetch(context.Background())
+			if tt.expectedError != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.expectedError) {
+					t.Errorf("error = %v, want %v", err, tt.expectedError)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if string(frame.Data) != tt.expectedData {
+				t.Errorf("data = %q, want %q", frame.Data, tt.expectedData)
+			}
+		})
+	}
+}
+
+// writeScript writes an executable shell script to a temporary directory.
+func writeScript(t *testing.T, script string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "capture.sh")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestExecCamera_FetchKillsChildren(t *testing.T) {
+	// The background sleep inherits stdout and would keep it open.
+	script := writeScript(t, "sleep 10 &\nsleep 10\n")
+	camera, err := NewExecCamera(config.CameraConfig{
+		Name:    "test",
+		Timeout: 100 * time.Millisecond,
+		Exec:    config.ExecConfig{Command: script},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	camera.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	start := time.Now()
+	_, err = camera.Fetch(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("error = %v, want timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > execWaitDelay+time.Second {
+		t.Errorf("Fetch returned after %v, want about the timeout", elapsed)
+	}
+}
+
+func TestExecCamera_FetchLogsStderr(t *testing.T) {
+	script := writeScript(t, "echo 'focus locked' >&2\necho image\n")
+	camera, err := NewExecCamera(config.CameraConfig{Name: "test", Exec: config.ExecConfig{Command: script}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var logs strings.Builder
+	camera.Logger = slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelInfo}))
+
+	if _, err := camera.Fetch(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(logs.String(), "level=INFO") || !strings.Contains(logs.String(), "stderr=\"focus locked\"") {
+		t.Errorf("logs = %q, want stderr at INFO", logs.String())
+	}
+}
+
+func TestExecCamera_FetchRedactsArgs(t *testing.T) {
+	camera, err := NewExecCamera(config.CameraConfig{Name: "test", Exec: config.ExecConfig{Command: "echo --password s3cr$t"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var logs strings.Builder
+	camera.Logger = slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	if _, err := camera.Fetch(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(logs.String(), "Executing capture command") || strings.Contains(logs.String(), "s3cr$t") {
+		t.Errorf("logs = %q, want the command without its arguments", logs.String())
+	}
+}
+
+func TestNewExecCamera_invalid(t *testing.T) {
+	tests := map[string]string{
+		"exec camera requires exec.command": "  ",
+		"parsing exec command":              "capture {{.Name",
+		"executing exec command template":   "capture {{.Missing}}",
+	}
+	for expectedError, command := range tests {
+		_, err := NewExecCamera(config.CameraConfig{Exec: config.ExecConfig{Command: command}})
+		if err == nil || !strings.Contains(err.Error(), expectedError) {
+			t.Errorf("error = %v, want %v", err, expectedError)
+		}
+	}
+}
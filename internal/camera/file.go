@@ -0,0 +1,251 @@
+package camera
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stone/timelapser/internal/config"
+	"github.com/stone/timelapser/internal/imageformat"
+)
+
+const (
+	// defaultArchiveDirName is the subdirectory of the watched directory that
+	// receives archived files unless file.archiveDir is set.
+	defaultArchiveDirName = "archive"
+	// maxFileAttempts is how often a file is read without being acknowledged,
+	// e.g. because it fails validation, before the next file is picked up.
+	maxFileAttempts = 3
+)
+
+func init() {
+	Register("file", func(cfg config.CameraConfig) (Source, error) {
+		return NewFileCamera(cfg)
+	})
+}
+
+// FileCamera picks up images that another device writes to a directory, e.g.
+// an SMB share, or to a fixed file path. Files are picked up in the order of
+// their modification time, which is also the time of the frame.
+type FileCamera struct {
+	Config config.CameraConfig
+
+	mu       sync.Mutex
+	seen     fileState // last file acknowledged
+	seenTime time.Time // time of the last acknowledged frame
+	pending  *Frame    // frame returned by Fetch that was not acknowledged yet
+	read     fileState // file pending was read from
+	attempts int       // fetches of read without an acknowledgement
+}
+
+// fileState identifies a version of a file, so an unchanged file is not
+// returned twice.
+type fileState struct {
+	path    string
+	modTime time.Time
+	size    int64
+}
+
+func NewFileCamera(cfg config.CameraConfig) (*FileCamera, error) {
+	if cfg.File.Path == "" {
+		return nil, errors.New("file camera requires file.path")
+	}
+	if cfg.File.Pattern != "" {
+		if _, err := filepath.Match(cfg.File.Pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid file pattern %q: %w", cfg.File.Pattern, err)
+		}
+	}
+	switch cfg.File.After {
+	case "", "keep", "remove", "archive":
+	default:
+		return nil, fmt.Errorf("unsupported file after action %q (keep, remove or archive)", cfg.File.After)
+	}
+	return &FileCamera{Config: cfg}, nil
+}
+
+// Fetch reads the next image: with file.after remove or archive the oldest
+// file newer than the last acknowledged one, otherwise the newest. It returns
+// ErrNotModified if there is no such file.
+func (c *FileCamera) Fetch(ctx context.Context) (*Frame, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pending = nil
+	var state fileState
+	for {
+		var err error
+		if state, err = c.next(); err != nil {
+			return nil, err
+		}
+		if state != c.read {
+			c.read, c.attempts = state, 0
+		}
+		if c.attempts < maxFileAttempts {
+			break
+		}
+		// The file keeps failing validation or storage, move on.
+		c.seen = state
+	}
+
+	c.attempts++
+	data, err := readFileLimited(state.path, maxSize(c.Config))
+	if err != nil {
+		return nil, fmt.Errorf("error reading image %s: %w", filepath.Base(state.path), err)
+	}
+	// Files modified within the same clock tick of the file system must
+	// still get distinct snapshot names.
+	t := state.modTime
+	if !t.After(c.seenTime) {
+		t = c.seenTime.Add(time.Nanosecond)
+	}
+	c.pending = &Frame{Data: data, Time: t}
+	return c.pending, nil
+}
+
+// next returns the file to read: the configured path if it is a file,
+// otherwise a matching file in the directory that is newer than the last
+// acknowledged one.
+func (c *FileCamera) next() (fileState, error) {
+	cfg := c.Config.File
+	info, err := os.Stat(cfg.Path)
+	if err != nil {
+		return fileState{}, fmt.Errorf("error reading image: %w", err)
+	}
+	if !info.IsDir() {
+		state := fileState{path: cfg.Path, modTime: info.ModTime(), size: info.Size()}
+		if time.Since(info.ModTime()) < cfg.MinAge || state == c.seen {
+			return fileState{}, ErrNotModified
+		}
+		return state, nil
+	}
+
+	entries, err := os.ReadDir(cfg.Path)
+	if err != nil {
+		return fileState{}, fmt.Errorf("error reading directory: %w", err)
+	}
+
+	// Removed and archived files are taken oldest first, so a backlog is
+	// stored in full; of kept files only the newest is of interest.
+	oldestFirst := cfg.After == "remove" || cfg.After == "archive"
+	var next fileState
+	var pending bool
+	for _, entry := range entries {
+		name := entry.Name()
+		// Hidden files are usually partial uploads (e.g. rsync, Samba).
+		if entry.IsDir() || strings.HasPrefix(name, ".") || !c.matches(name) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue // removed since ReadDir
+		}
+		state := fileState{path: filepath.Join(cfg.Path, name), modTime: info.ModTime(), size: info.Size()}
+		if time.Since(info.ModTime()) < cfg.MinAge || (c.seen.path != "" && !state.after(c.seen)) {
+			pending = true
+			continue
+		}
+		if next.path == "" || state.after(next) != oldestFirst {
+			next = state
+		}
+	}
+
+	if next.path == "" {
+		if pending {
+			return fileState{}, ErrNotModified
+		}
+		return fileState{}, fmt.Errorf("no image found in %s", cfg.Path)
+	}
+	return next, nil
+}
+
+// after reports whether s was modified after o. Ties are broken by name, which
+// for most devices contains a timestamp.
+func (s fileState) after(o fileState) bool {
+	if !s.modTime.Equal(o.modTime) {
+		return s.modTime.After(o.modTime)
+	}
+	return filepath.Base(s.path) > filepath.Base(o.path)
+}
+
+func (c *FileCamera) matches(name string) bool {
+	if c.Config.File.Pattern == "" {
+		return imageformat.IsImage(name)
+	}
+	ok, _ := filepath.Match(c.Config.File.Pattern, name)
+	return ok
+}
+
+// Ack marks the file frame was read from as handled, so it is not returned
+// again, and removes or archives it depending on file.after.
+func (c *FileCamera) Ack(frame *Frame) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if frame == nil || frame != c.pending {
+		return nil
+	}
+	c.pending = nil
+	c.seen, c.seenTime = c.read, frame.Time
+	path := c.seen.path
+
+	switch c.Config.File.After {
+	case "remove":
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("failed to remove source file: %w", err)
+		}
+	case "archive":
+		archiveDir := c.Config.File.ArchiveDir
+		if archiveDir == "" {
+			archiveDir = filepath.Join(filepath.Dir(path), defaultArchiveDirName)
+		}
+		if err := os.MkdirAll(archiveDir, 0o755); err != nil {
+			return fmt.Errorf("failed to create archive directory: %w", err)
+		}
+		if err := moveFile(path, filepath.Join(archiveDir, filepath.Base(path))); err != nil {
+			return fmt.Errorf("failed to archive source file: %w", err)
+		}
+	}
+	return nil
+}
+
+// moveFile renames src to dst, falling back to copy and remove when they are
+// on different file systems, as is common with mounted shares.
+func moveFile(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmp := dst + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Remove(src)
+}
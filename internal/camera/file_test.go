@@ -0,0 +1,264 @@
+package camera
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stone/timelapser/internal/config"
+)
+
+// writeImage writes data to dir/name with the given modification time.
+func writeImage(t *testing.T, dir, name, data string, modTime time.Time) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatalf("writing %s: %v", name, err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatalf("setting time of %s: %v", name, err)
+	}
+	return path
+}
+
+func mustNewFileCamera(t *testing.T, cfg config.FileConfig) *FileCamera {
+	t.Helper()
+	camera, err := NewFileCamera(config.CameraConfig{Name: "share", File: cfg})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return camera
+}
+
+func TestFileCamera_Fetch(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name          string
+		files         map[string]time.Duration // name → age
+		pattern       string
+		minAge        time.Duration
+		expectedData  string
+		expectedError string
+	}{
+		{
+			name:         "newest image",
+			files:        map[string]time.Duration{"a.jpg": 3 * time.Minute, "b.jpg": time.Minute, "c.jpg": 2 * time.Minute},
+			expectedData: "b.jpg",
+		},
+		{
+			name:         "ignores hidden and non-image files",
+			files:        map[string]time.Duration{"a.jpg": time.Minute, ".b.jpg": 0, "c.txt": 0},
+			expectedData: "a.jpg",
+		},
+		{
+			name:         "pattern",
+			files:        map[string]time.Duration{"cam1_001.jpg": time.Minute, "cam2_001.jpg": 0},
+			pattern:      "cam1_*",
+			expectedData: "cam1_001.jpg",
+		},
+		{
+			name:         "skips files younger than min age",
+			files:        map[string]time.Duration{"a.jpg": time.Minute, "b.jpg": 0},
+			minAge:       10 * time.Second,
+			expectedData: "a.jpg",
+		},
+		{
+			name:          "only files younger than min age",
+			files:         map[string]time.Duration{"a.jpg": 0},
+			minAge:        10 * time.Second,
+			expectedError: ErrNotModified.Error(),
+		},
+		{
+			name:          "empty directory",
+			files:         map[string]time.Duration{},
+			expectedError: "no image found in",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, age := range tt.files {
+				writeImage(t, dir, name, name, now.Add(-age))
+			}
+			camera := mustNewFileCamera(t, config.FileConfig{Path: dir, Pattern: tt.pattern, MinAge: tt.minAge})
+
+			frame, err := camera.Fetch(context.Background())
+			if tt.expectedError != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.expectedError) {
+					t.Errorf("error = %v, want %v", err, tt.expectedError)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if string(frame.Data) != tt.expectedData {
+				t.Errorf("data = %q, want %q", frame.Data, tt.expectedData)
+			}
+		})
+	}
+}
+
+func TestFileCamera_FetchUnchanged(t *testing.T) {
+	dir := t.TempDir()
+	path := writeImage(t, dir, "snapshot.jpg", "first", time.Now().Add(-time.Minute))
+	camera := mustNewFileCamera(t, config.FileConfig{Path: path})
+
+	frame, err := camera.Fetch(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := camera.Ack(frame); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := camera.Fetch(context.Background()); !errors.Is(err, ErrNotModified) {
+		t.Errorf("second fetch error = %v, want ErrNotModified", err)
+	}
+
+	writeImage(t, dir, "snapshot.jpg", "second", time.Now())
+	frame, err = camera.Fetch(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(frame.Data) != "second" {
+		t.Errorf("data = %q, want %q", frame.Data, "second")
+	}
+}
+
+func TestFileCamera_Ack(t *testing.T) {
+	tests := []struct {
+		name            string
+		after           string
+		archiveDir      string
+		expectedSource  bool
+		expectedArchive string
+	}{
+		{name: "keep", after: "", expectedSource: true},
+		{name: "remove", after: "remove"},
+		{name: "archive", after: "archive", expectedArchive: "archive"},
+		{name: "archive dir", after: "archive", archiveDir: "done", expectedArchive: "done"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			dir := filepath.Join(root, "drop")
+			os.Mkdir(dir, 0o755)
+			path := writeImage(t, dir, "image.jpg", "data", time.Now())
+
+			cfg := config.FileConfig{Path: dir, After: tt.after}
+			if tt.archiveDir != "" {
+				cfg.ArchiveDir = filepath.Join(root, tt.archiveDir)
+			}
+			camera := mustNewFileCamera(t, cfg)
+
+			frame, err := camera.Fetch(context.Background())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err := camera.Ack(frame); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if _, err := os.Stat(path); (err == nil) != tt.expectedSource {
+				t.Errorf("source file exists = %v, want %v", err == nil, tt.expectedSource)
+			}
+			if tt.expectedArchive != "" {
+				archived := filepath.Join(dir, "archive", "image.jpg")
+				if tt.archiveDir != "" {
+					archived = filepath.Join(root, tt.archiveDir, "image.jpg")
+				}
+				if data, err := os.ReadFile(archived); err != nil || string(data) != "data" {
+					t.Errorf("archived file = %q, %v", data, err)
+				}
+			}
+		})
+	}
+}
+
+func TestFileCamera_FetchInOrder(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+
+	for _, after := range []string{"remove", "archive"} {
+		t.Run(after, func(t *testing.T) {
+			dir := t.TempDir()
+			writeImage(t, dir, "new.jpg", "new", now.Add(-time.Minute))
+			writeImage(t, dir, "old.jpg", "old", now.Add(-2*time.Minute))
+			// Same modification time as new.jpg, taken after it by name.
+			writeImage(t, dir, "new2.jpg", "new2", now.Add(-time.Minute))
+			camera := mustNewFileCamera(t, config.FileConfig{Path: dir, After: after})
+
+			expected := []struct {
+				data string
+				time time.Time
+			}{
+				{"old", now.Add(-2 * time.Minute)},
+				{"new", now.Add(-time.Minute)},
+				{"new2", now.Add(-time.Minute + time.Nanosecond)},
+			}
+			for _, want := range expected {
+				frame, err := camera.Fetch(context.Background())
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if string(frame.Data) != want.data || !frame.Time.Equal(want.time) {
+					t.Errorf("frame = %q at %v, want %q at %v", frame.Data, frame.Time, want.data, want.time)
+				}
+				if err := camera.Ack(frame); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+			if _, err := camera.Fetch(context.Background()); err == nil || !strings.Contains(err.Error(), "no image found") {
+				t.Errorf("error = %v, want no image found", err)
+			}
+		})
+	}
+}
+
+func TestFileCamera_FetchRetry(t *testing.T) {
+	dir := t.TempDir()
+	writeImage(t, dir, "broken.jpg", "broken", time.Now().Add(-2*time.Minute))
+	writeImage(t, dir, "image.jpg", "image", time.Now().Add(-time.Minute))
+	camera := mustNewFileCamera(t, config.FileConfig{Path: dir, After: "remove"})
+
+	// A frame that is not acknowledged, e.g. because storing it failed, is
+	// read again until it was tried maxFileAttempts times.
+	for range maxFileAttempts {
+		frame, err := camera.Fetch(context.Background())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if string(frame.Data) != "broken" {
+			t.Fatalf("data = %q, want %q", frame.Data, "broken")
+		}
+	}
+	frame, err := camera.Fetch(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(frame.Data) != "image" {
+		t.Errorf("data = %q, want %q after giving up on broken.jpg", frame.Data, "image")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "broken.jpg")); err != nil {
+		t.Errorf("unacknowledged file was removed: %v", err)
+	}
+}
+
+func TestNewFileCamera_invalid(t *testing.T) {
+	tests := map[string]config.FileConfig{
+		"file camera requires file.path":      {},
+		"invalid file pattern":                {Path: "/tmp", Pattern: "["},
+		"unsupported file after action \"x\"": {Path: "/tmp", After: "x"},
+	}
+	for expectedError, cfg := range tests {
+		if _, err := NewFileCamera(config.CameraConfig{File: cfg}); err == nil || !strings.Contains(err.Error(), expectedError) {
+			t.Errorf("error = %v, want %v", err, expectedError)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package camera
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/stone/timelapser/internal/config"
+)
+
+var errLoginNotConfigured = errors.New("login auth requires auth.login.url")
+
+// loginSession logs in through a web form and relies on the camera's cookie
+// jar to carry the session cookie on later snapshot requests.
+type loginSession struct {
+	cfg      config.LoginConfig
+	username string
+	password string
+	jar      http.CookieJar
+
+	mu       sync.Mutex
+	loggedIn bool
+}
+
+func newLoginSession(auth config.AuthConfig, jar http.CookieJar) *loginSession {
+	return &loginSession{
+		cfg:      auth.Login,
+		username: auth.Username,
+		password: auth.Password,
+		jar:      jar,
+	}
+}
+
+func (l *loginSession) active() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.loggedIn
+}
+
+// login posts the credentials to the login form and checks the configured
+// success criteria.
+func (l *loginSession) login(ctx context.Context, client HTTPClient) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.loggedIn = false
+
+	loginURL, err := url.Parse(l.cfg.URL)
+	if err != nil {
+		return fmt.Errorf("invalid login url: %w", err)
+	}
+
+	form := url.Values{}
+	for key, value := range l.cfg.Fields {
+		form.Set(key, value)
+	}
+	form.Set(fieldOrDefault(l.cfg.UsernameField, "username"), l.username)
+	form.Set(fieldOrDefault(l.cfg.PasswordField, "password"), l.password)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, loginURL.String(), strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error making login request: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if l.cfg.SuccessStatus != 0 {
+		if resp.StatusCode != l.cfg.SuccessStatus {
+			return fmt.Errorf("login returned status code %d, want %d", resp.StatusCode, l.cfg.SuccessStatus)
+		}
+	} else if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("login returned status code %d", resp.StatusCode)
+	}
+
+	if l.cfg.SuccessCookie != "" && !l.hasCookie(loginURL, resp) {
+		return fmt.Errorf("login did not set cookie %q", l.cfg.SuccessCookie)
+	}
+
+	l.loggedIn = true
+	return nil
+}
+
+func (l *loginSession) hasCookie(loginURL *url.URL, resp *http.Response) bool {
+	cookies := resp.Cookies()
+	if l.jar != nil {
+		cookies = append(cookies, l.jar.Cookies(loginURL)...)
+	}
+	for _, cookie := range cookies {
+		if cookie.Name == l.cfg.SuccessCookie && cookie.Value != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// expired reports whether a snapshot response shows that the session is no
+// longer valid: the camera refused the request or sent us to the login page.
+func (l *loginSession) expired(resp *http.Response) bool {
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return true
+	}
+	if resp.Request == nil || resp.Request.URL == nil {
+		return false
+	}
+	return resp.Request.URL.Path == l.pagePath()
+}
+
+// pagePath returns the path of the login page the camera redirects to.
+func (l *loginSession) pagePath() string {
+	page := l.cfg.Page
+	if page == "" {
+		page = l.cfg.URL
+	}
+	u, err := url.Parse(page)
+	if err != nil {
+		return ""
+	}
+	return u.Path
+}
+
+func fieldOrDefault(field, def string) string {
+	if field == "" {
+		return def
+	}
+	return field
+}
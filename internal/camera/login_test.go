@@ -0,0 +1,123 @@
+package camera
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stone/timelapser/internal/config"
+)
+
+// nvrServer mimics an NVR web UI: /login.cgi hands out a session cookie and
+// /snapshot.jpg redirects to /login.html without a valid session.
+type nvrServer struct {
+	session atomic.Value
+	logins  atomic.Int32
+}
+
+func newNVRServer() (*httptest.Server, *nvrServer) {
+	nvr := &nvrServer{}
+	nvr.session.Store("")
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /login.cgi", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("user") != "admin" || r.FormValue("pwd") != "secret" || r.FormValue("lang") != "en" {
+			http.Redirect(w, r, "/login.html", http.StatusFound)
+			return
+		}
+		session := fmt.Sprintf("session-%d", nvr.logins.Add(1))
+		nvr.session.Store(session)
+		http.SetCookie(w, &http.Cookie{Name: "SID", Value: session, Path: "/"})
+		http.Redirect(w, r, "/index.html", http.StatusFound)
+	})
+	mux.HandleFunc("GET /index.html", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("GET /login.html", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<html>login</html>"))
+	})
+	mux.HandleFunc("GET /snapshot.jpg", func(w http.ResponseWriter, r *http.Request) {
+		cookie, err := r.Cookie("SID")
+		if err != nil || cookie.Value != nvr.session.Load().(string) {
+			http.Redirect(w, r, "/login.html", http.StatusFound)
+			return
+		}
+		w.Write([]byte("image data"))
+	})
+	return httptest.NewServer(mux), nvr
+}
+
+func loginCameraConfig(serverURL, password string) config.CameraConfig {
+	return config.CameraConfig{
+		SnapshotURL: serverURL + "/snapshot.jpg",
+		Auth: config.AuthConfig{
+			Type:     "login",
+			Username: "admin",
+			Password: password,
+			Login: config.LoginConfig{
+				URL:           serverURL + "/login.cgi",
+				Page:          "/login.html",
+				UsernameField: "user",
+				PasswordField: "pwd",
+				Fields:        map[string]string{"lang": "en"},
+				SuccessCookie: "SID",
+			},
+		},
+	}
+}
+
+func TestCamera_fetchLogin(t *testing.T) {
+	server, nvr := newNVRServer()
+	defer server.Close()
+
+	camera := mustNewCamera(t, loginCameraConfig(server.URL, "secret"))
+
+	for i := 0; i < 2; i++ {
+		frame, err := camera.Fetch(context.Background())
+		if err != nil {
+			t.Fatalf("fetch %d: %v", i, err)
+		}
+		data := frameData(t, frame)
+		if string(data) != "image data" {
+			t.Errorf("data = %q, want %q", data, "image data")
+		}
+	}
+	if got := nvr.logins.Load(); got != 1 {
+		t.Errorf("logins = %d, want 1 (session reused)", got)
+	}
+
+	// The session expires: the snapshot redirects to the login page and
+	// the camera logs in again without surfacing an error.
+	nvr.session.Store("expired")
+	frame, err := camera.Fetch(context.Background())
+	if err != nil {
+		t.Fatalf("fetch after expiry: %v", err)
+	}
+	data := frameData(t, frame)
+	if string(data) != "image data" {
+		t.Errorf("data = %q, want %q", data, "image data")
+	}
+	if got := nvr.logins.Load(); got != 2 {
+		t.Errorf("logins = %d, want 2", got)
+	}
+}
+
+func TestCamera_fetchLoginWrongPassword(t *testing.T) {
+	server, _ := newNVRServer()
+	defer server.Close()
+
+	camera := mustNewCamera(t, loginCameraConfig(server.URL, "wrong"))
+
+	_, err := camera.Fetch(context.Background())
+	if err == nil || !strings.Contains(err.Error(), `login did not set cookie "SID"`) {
+		t.Errorf("error = %v, want login failure", err)
+	}
+}
+
+func TestNewSource_loginRequiresURL(t *testing.T) {
+	_, err := NewSource(config.CameraConfig{Auth: config.AuthConfig{Type: "login"}})
+	if err != errLoginNotConfigured {
+		t.Errorf("error = %v, want %v", err, errLoginNotConfigured)
+	}
+}
@@ -0,0 +1,110 @@
+package camera
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/stone/timelapser/internal/config"
+)
+
+const (
+	defaultMJPEGTimeout = 10 * time.Second
+	mjpegContentType    = "multipart/x-mixed-replace"
+)
+
+func init() {
+	Register("mjpeg", newHTTPSource)
+}
+
+// isMJPEG reports whether contentType is a multipart/x-mixed-replace stream.
+func isMJPEG(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && mediaType == mjpegContentType
+}
+
+// mjpegMaxFrameSize returns mjpeg.maxFrameSize, or the maxSize of the camera
+// when it is not set.
+func mjpegMaxFrameSize(cfg config.CameraConfig) int64 {
+	if cfg.MJPEG.MaxFrameSize > 0 {
+		return cfg.MJPEG.MaxFrameSize
+	}
+	return maxSize(cfg)
+}
+
+func mjpegTimeout(cfg config.MJPEGConfig) time.Duration {
+	if cfg.Timeout > 0 {
+		return cfg.Timeout
+	}
+	return defaultMJPEGTimeout
+}
+
+// readMJPEGFrame returns the first complete part of a multipart/x-mixed-replace
+// stream, spooled like other HTTP snapshots, with the content type of the
+// part. Reading stops as soon as the part is complete so the caller can close
+// the connection; parts larger than maxSize are rejected with ErrTooLarge.
+func readMJPEGFrame(ctx context.Context, body io.Reader, contentType string, maxSize int64) (*Frame, error) {
+	_, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, fmt.Errorf("invalid content type %q: %w", contentType, err)
+	}
+	// Several cameras announce the boundary including the leading dashes.
+	boundary := strings.TrimPrefix(params["boundary"], "--")
+	if boundary == "" {
+		return nil, errors.New("missing multipart boundary")
+	}
+
+	part, err := multipart.NewReader(body, boundary).NextPart()
+	if err != nil {
+		return nil, fmt.Errorf("reading mjpeg part: %w", err)
+	}
+	// part.Close is deliberately not called: it drains the part up to the
+	// next boundary, which on a live stream means waiting for another frame.
+
+	partType := part.Header.Get("Content-Type")
+	if partType == "" {
+		partType = "image/jpeg"
+	}
+
+	// With a Content-Length we can stop right after the frame instead of
+	// waiting for the boundary that only arrives with the next frame.
+	if length := part.Header.Get("Content-Length"); length != "" {
+		n, err := strconv.ParseInt(strings.TrimSpace(length), 10, 64)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid mjpeg part length %q", length)
+		}
+		if n > maxSize {
+			return nil, fmt.Errorf("%w: mjpeg frame of %d bytes exceeds limit of %d bytes", ErrTooLarge, n, maxSize)
+		}
+		frame, err := spool(ctx, io.LimitReader(part, n), n)
+		if err == nil && frame.size < n {
+			frame.Close()
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading mjpeg frame: %w", err)
+		}
+		frame.ContentType = partType
+		return frame, nil
+	}
+
+	frame, err := spool(ctx, part, maxSize)
+	if errors.Is(err, ErrTooLarge) {
+		return nil, fmt.Errorf("%w: mjpeg frame exceeds limit of %d bytes", ErrTooLarge, maxSize)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading mjpeg frame: %w", err)
+	}
+	if frame.size == 0 {
+		frame.Close()
+		return nil, errors.New("empty mjpeg frame")
+	}
+	frame.ContentType = partType
+	return frame, nil
+}
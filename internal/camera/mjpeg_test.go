@@ -0,0 +1,136 @@
+package camera
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stone/timelapser/internal/config"
+)
+
+// mjpegHandler serves an endless MJPEG stream that sends a single frame and
+// then stalls, like a camera waiting for the next image.
+func mjpegHandler(frame []byte, withLength bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "multipart/x-mixed-replace; boundary=--frameboundary")
+		fmt.Fprint(w, "--frameboundary\r\nContent-Type: image/jpeg\r\n")
+		if withLength {
+			fmt.Fprintf(w, "Content-Length: %d\r\n", len(frame))
+		}
+		fmt.Fprint(w, "\r\n")
+		w.Write(frame)
+		fmt.Fprint(w, "\r\n")
+		if !withLength {
+			// Without a length the frame ends at the next boundary.
+			fmt.Fprint(w, "--frameboundary\r\nContent-Type: image/jpeg\r\n\r\n")
+		}
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}
+}
+
+func TestCamera_fetchMJPEG(t *testing.T) {
+	frame := []byte("\xff\xd8jpeg frame data\xff\xd9")
+
+	tests := []struct {
+		name          string
+		handler       http.HandlerFunc
+		config        config.CameraConfig
+		expectedData  []byte
+		expectedError string
+	}{
+		{
+			name:         "frame with content length",
+			handler:      mjpegHandler(frame, true),
+			config:       config.CameraConfig{Type: "mjpeg"},
+			expectedData: frame,
+		},
+		{
+			name:         "frame delimited by boundary",
+			handler:      mjpegHandler(frame, false),
+			config:       config.CameraConfig{Type: "mjpeg"},
+			expectedData: frame,
+		},
+		{
+			name:         "detected on http camera",
+			handler:      mjpegHandler(frame, true),
+			config:       config.CameraConfig{},
+			expectedData: frame,
+		},
+		{
+			name:          "frame exceeds limit",
+			handler:       mjpegHandler(frame, true),
+			config:        config.CameraConfig{Type: "mjpeg", MJPEG: config.MJPEGConfig{MaxFrameSize: 4}},
+			expectedError: "exceeds limit",
+		},
+		{
+			name:          "frame exceeds max size",
+			handler:       mjpegHandler(frame, true),
+			config:        config.CameraConfig{Type: "mjpeg", MaxSize: 4},
+			expectedError: "snapshot too large: mjpeg frame of 19 bytes exceeds limit of 4 bytes",
+		},
+		{
+			name:          "frame delimited by boundary exceeds max size",
+			handler:       mjpegHandler(frame, false),
+			config:        config.CameraConfig{Type: "mjpeg", MaxSize: 4},
+			expectedError: "snapshot too large: mjpeg frame exceeds limit of 4 bytes",
+		},
+		{
+			name: "no frame before timeout",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "multipart/x-mixed-replace; boundary=frameboundary")
+				w.(http.Flusher).Flush()
+				<-r.Context().Done()
+			},
+			config:        config.CameraConfig{Type: "mjpeg", MJPEG: config.MJPEGConfig{Timeout: 50 * time.Millisecond}},
+			expectedError: "timed out waiting for mjpeg frame",
+		},
+		{
+			name: "mjpeg camera returns single image",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "image/jpeg")
+				w.Write(frame)
+			},
+			config:        config.CameraConfig{Type: "mjpeg"},
+			expectedError: "expected multipart/x-mixed-replace response",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(tt.handler)
+			defer server.Close()
+
+			tt.config.SnapshotURL = server.URL
+			camera := mustNewCamera(t, tt.config)
+
+			frame, err := camera.Fetch(context.Background())
+
+			if tt.expectedError != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.expectedError) {
+					t.Errorf("error = %v, want %v", err, tt.expectedError)
+				}
+				if strings.Contains(tt.expectedError, "snapshot too large") && !errors.Is(err, ErrTooLarge) {
+					t.Errorf("error = %v, want ErrTooLarge", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			data := frameData(t, frame)
+			if !bytes.Equal(data, tt.expectedData) {
+				t.Errorf("data = %q, want %q", data, tt.expectedData)
+			}
+			if frame.ContentType != "image/jpeg" {
+				t.Errorf("content type = %q, want image/jpeg", frame.ContentType)
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package camera
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stone/timelapser/internal/config"
+)
+
+// tokenExpiryDelta refreshes tokens this long before they expire, so a token
+// does not run out between being attached and reaching the camera API.
+const tokenExpiryDelta = 30 * time.Second
+
+var errOAuth2NotConfigured = errors.New("oauth2 auth requires auth.oauth2.tokenUrl and auth.oauth2.clientId")
+
+// oauth2Token implements the OAuth2 client credentials grant (RFC 6749
+// section 4.4) and caches the access token until shortly before it expires.
+type oauth2Token struct {
+	cfg config.OAuth2Config
+
+	mu          sync.Mutex
+	accessToken string
+	expiry      time.Time // zero if the server did not say
+}
+
+func newOAuth2Token(cfg config.OAuth2Config) *oauth2Token {
+	return &oauth2Token{cfg: cfg}
+}
+
+// token returns a valid access token, requesting a new one when none is
+// cached or the cached one is about to expire.
+func (o *oauth2Token) token(ctx context.Context, client HTTPClient) (string, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.accessToken != "" && (o.expiry.IsZero() || time.Now().Add(tokenExpiryDelta).Before(o.expiry)) {
+		return o.accessToken, nil
+	}
+
+	accessToken, expiresIn, err := o.request(ctx, client)
+	if err != nil {
+		return "", err
+	}
+	o.accessToken = accessToken
+	o.expiry = time.Time{}
+	if expiresIn > 0 {
+		o.expiry = time.Now().Add(time.Duration(expiresIn) * time.Second)
+	}
+	return o.accessToken, nil
+}
+
+// invalidate drops the cached token after the camera API rejected it.
+func (o *oauth2Token) invalidate() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.accessToken = ""
+}
+
+func (o *oauth2Token) request(ctx context.Context, client HTTPClient) (string, int64, error) {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(o.cfg.Scopes) > 0 {
+		form.Set("scope", strings.Join(o.cfg.Scopes, " "))
+	}
+	if o.cfg.AuthStyle == "params" {
+		form.Set("client_id", o.cfg.ClientID)
+		form.Set("client_secret", o.cfg.ClientSecret)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.cfg.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", 0, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if o.cfg.AuthStyle != "params" {
+		req.SetBasicAuth(url.QueryEscape(o.cfg.ClientID), url.QueryEscape(o.cfg.ClientSecret))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", 0, fmt.Errorf("error requesting token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", 0, fmt.Errorf("error reading token response: %w", err)
+	}
+
+	var token struct {
+		AccessToken      string `json:"access_token"`
+		TokenType        string `json:"token_type"`
+		ExpiresIn        int64  `json:"expires_in"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.Unmarshal(body, &token); err != nil && resp.StatusCode == http.StatusOK {
+		return "", 0, fmt.Errorf("error parsing token response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		if token.Error != "" {
+			return "", 0, fmt.Errorf("token endpoint returned %d: %s %s", resp.StatusCode, token.Error, token.ErrorDescription)
+		}
+		return "", 0, fmt.Errorf("token endpoint returned status code %d", resp.StatusCode)
+	}
+	if token.AccessToken == "" {
+		return "", 0, errors.New("token response without access_token")
+	}
+	if token.TokenType != "" && !strings.EqualFold(token.TokenType, "bearer") {
+		return "", 0, fmt.Errorf("unsupported token type %q", token.TokenType)
+	}
+
+	return token.AccessToken, token.ExpiresIn, nil
+}
@@ -0,0 +1,141 @@
+package camera
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stone/timelapser/internal/config"
+)
+
+// cloudAPI is a token endpoint plus a snapshot API that accepts the most
+// recently issued token.
+type cloudAPI struct {
+	expiresIn int
+	issued    atomic.Int32
+
+	mu    sync.Mutex
+	valid string
+}
+
+func (c *cloudAPI) revoke() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.valid = ""
+}
+
+func newCloudAPI(t *testing.T, expiresIn int) (*httptest.Server, *cloudAPI) {
+	api := &cloudAPI{expiresIn: expiresIn}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /oauth/token", func(w http.ResponseWriter, r *http.Request) {
+		id, secret, _ := r.BasicAuth()
+		if r.FormValue("grant_type") != "client_credentials" || id != "timelapser" || secret != "s3cret" {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"error":"invalid_client","error_description":"bad credentials"}`)
+			return
+		}
+		if r.FormValue("scope") != "snapshot:read camera:list" {
+			t.Errorf("scope = %q", r.FormValue("scope"))
+		}
+		token := fmt.Sprintf("token-%d", api.issued.Add(1))
+		api.mu.Lock()
+		api.valid = token
+		api.mu.Unlock()
+		json.NewEncoder(w).Encode(map[string]any{
+			"access_token": token,
+			"token_type":   "Bearer",
+			"expires_in":   api.expiresIn,
+		})
+	})
+	mux.HandleFunc("GET /snapshot", func(w http.ResponseWriter, r *http.Request) {
+		api.mu.Lock()
+		valid := api.valid
+		api.mu.Unlock()
+		if valid == "" || r.Header.Get("Authorization") != "Bearer "+valid {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte("image data"))
+	})
+	return httptest.NewServer(mux), api
+}
+
+func oauth2CameraConfig(serverURL, secret string) config.CameraConfig {
+	return config.CameraConfig{
+		SnapshotURL: serverURL + "/snapshot",
+		Auth: config.AuthConfig{
+			Type: "oauth2",
+			OAuth2: config.OAuth2Config{
+				TokenURL:     serverURL + "/oauth/token",
+				ClientID:     "timelapser",
+				ClientSecret: secret,
+				Scopes:       []string{"snapshot:read", "camera:list"},
+			},
+		},
+	}
+}
+
+func TestCamera_fetchOAuth2(t *testing.T) {
+	server, api := newCloudAPI(t, 3600)
+	defer server.Close()
+
+	camera := mustNewCamera(t, oauth2CameraConfig(server.URL, "s3cret"))
+	fetch := func() {
+		t.Helper()
+		frame, err := camera.Fetch(context.Background())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		data := frameData(t, frame)
+		if string(data) != "image data" {
+			t.Errorf("data = %q, want %q", data, "image data")
+		}
+	}
+
+	fetch()
+	fetch()
+	if got := api.issued.Load(); got != 1 {
+		t.Errorf("tokens issued = %d, want 1 (cached)", got)
+	}
+
+	// A revoked token is replaced after the 401 and the request retried.
+	api.revoke()
+	fetch()
+	if got := api.issued.Load(); got != 2 {
+		t.Errorf("tokens issued = %d, want 2", got)
+	}
+}
+
+func TestCamera_fetchOAuth2Expiry(t *testing.T) {
+	// Tokens shorter lived than the expiry margin are refreshed every time.
+	server, api := newCloudAPI(t, 10)
+	defer server.Close()
+
+	camera := mustNewCamera(t, oauth2CameraConfig(server.URL, "s3cret"))
+	for i := 0; i < 3; i++ {
+		if _, err := camera.Fetch(context.Background()); err != nil {
+			t.Fatalf("fetch %d: %v", i, err)
+		}
+	}
+	if got := api.issued.Load(); got != 3 {
+		t.Errorf("tokens issued = %d, want 3", got)
+	}
+}
+
+func TestCamera_fetchOAuth2InvalidClient(t *testing.T) {
+	server, _ := newCloudAPI(t, 3600)
+	defer server.Close()
+
+	camera := mustNewCamera(t, oauth2CameraConfig(server.URL, "wrong"))
+	_, err := camera.Fetch(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "invalid_client bad credentials") {
+		t.Errorf("error = %v, want invalid_client", err)
+	}
+}
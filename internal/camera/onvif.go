@@ -0,0 +1,258 @@
+package camera
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stone/timelapser/internal/config"
+)
+
+// onvifDevicePath is the standard path of the ONVIF device service.
+const onvifDevicePath = "/onvif/device_service"
+
+func init() {
+	Register("onvif", func(cfg config.CameraConfig) (Source, error) {
+		return NewONVIFCamera(cfg)
+	})
+}
+
+// ONVIFCamera discovers the snapshot URI of an ONVIF camera with
+// GetProfiles/GetSnapshotUri and fetches snapshots from it like an http
+// camera. The URI is cached until the camera answers it with 404 or 400.
+type ONVIFCamera struct {
+	Config config.CameraConfig
+	Client HTTPClient
+
+	deviceURL string
+
+	mu       sync.Mutex
+	snapshot *Camera // camera for the discovered URI, nil until discovered
+}
+
+func NewONVIFCamera(cfg config.CameraConfig) (*ONVIFCamera, error) {
+	if cfg.ONVIF.Host == "" {
+		return nil, errors.New("onvif camera requires onvif.host")
+	}
+	deviceURL := cfg.ONVIF.Host
+	if !strings.Contains(deviceURL, "://") {
+		deviceURL = "http://" + strings.TrimSuffix(deviceURL, "/") + onvifDevicePath
+	}
+
+	transport, err := sharedTransport(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &ONVIFCamera{
+		Config:    cfg,
+		Client:    &http.Client{Transport: limitedTransport{next: transport}},
+		deviceURL: deviceURL,
+	}, nil
+}
+
+func (c *ONVIFCamera) Fetch(ctx context.Context) (*Frame, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.snapshot == nil {
+		snapshotURL, err := c.discover(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("onvif discovery failed: %w", err)
+		}
+		cfg := c.Config
+		cfg.SnapshotURL = snapshotURL
+		if cfg.Auth.Type == "" && cfg.Auth.Username != "" {
+			cfg.Auth.Type = "digest" // what most ONVIF cameras expect for snapshots
+		}
+		if c.snapshot, err = NewCamera(cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	frame, err := c.snapshot.Fetch(ctx)
+	if staleSnapshotURI(err) {
+		// The URI may have changed, e.g. after a firmware update.
+		c.snapshot = nil
+	}
+	return frame, err
+}
+
+// staleSnapshotURI reports whether a fetch failed because the camera no longer
+// knows the snapshot URI. Timeouts and server errors keep the cached URI, so a
+// flaky camera is not asked for its profiles on every capture.
+func staleSnapshotURI(err error) bool {
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) {
+		return false
+	}
+	return statusErr.Code == http.StatusNotFound || statusErr.Code == http.StatusBadRequest
+}
+
+// Ack passes the acknowledgement on to the camera of the snapshot URI, which
+// makes its next request conditional.
+func (c *ONVIFCamera) Ack(frame *Frame) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.snapshot == nil {
+		return nil
+	}
+	return c.snapshot.Ack(frame)
+}
+
+// discover returns the snapshot URI of the configured or first media profile.
+func (c *ONVIFCamera) discover(ctx context.Context) (string, error) {
+	if c.Config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Config.Timeout)
+		defer cancel()
+	}
+
+	// WS-Security rejects requests from clients whose clock is too far off.
+	offset := c.clockOffset(ctx)
+
+	var caps struct {
+		XAddr string `xml:"Body>GetCapabilitiesResponse>Capabilities>Media>XAddr"`
+	}
+	if err := c.call(ctx, c.deviceURL, offset,
+		`<GetCapabilities xmlns="http://www.onvif.org/ver10/device/wsdl"><Category>Media</Category></GetCapabilities>`, &caps); err != nil {
+		return "", fmt.Errorf("GetCapabilities: %w", err)
+	}
+	mediaURL := strings.TrimSpace(caps.XAddr)
+	if mediaURL == "" {
+		return "", errors.New("camera has no media service")
+	}
+
+	profile := c.Config.ONVIF.Profile
+	if profile == "" {
+		var profiles struct {
+			Profiles []struct {
+				Token string `xml:"token,attr"`
+			} `xml:"Body>GetProfilesResponse>Profiles"`
+		}
+		if err := c.call(ctx, mediaURL, offset,
+			`<GetProfiles xmlns="http://www.onvif.org/ver10/media/wsdl"/>`, &profiles); err != nil {
+			return "", fmt.Errorf("GetProfiles: %w", err)
+		}
+		if len(profiles.Profiles) == 0 {
+			return "", errors.New("camera has no media profiles")
+		}
+		profile = profiles.Profiles[0].Token
+	}
+
+	var uri struct {
+		URI string `xml:"Body>GetSnapshotUriResponse>MediaUri>Uri"`
+	}
+	if err := c.call(ctx, mediaURL, offset,
+		`<GetSnapshotUri xmlns="http://www.onvif.org/ver10/media/wsdl"><ProfileToken>`+xmlEscape(profile)+`</ProfileToken></GetSnapshotUri>`, &uri); err != nil {
+		return "", fmt.Errorf("GetSnapshotUri: %w", err)
+	}
+	if uri.URI == "" {
+		return "", fmt.Errorf("no snapshot uri for profile %q", profile)
+	}
+	return strings.TrimSpace(uri.URI), nil
+}
+
+// clockOffset returns how far the camera clock is ahead of ours, or zero if
+// the camera does not tell.
+func (c *ONVIFCamera) clockOffset(ctx context.Context) time.Duration {
+	var dt struct {
+		UTC struct {
+			Year   int `xml:"Date>Year"`
+			Month  int `xml:"Date>Month"`
+			Day    int `xml:"Date>Day"`
+			Hour   int `xml:"Time>Hour"`
+			Minute int `xml:"Time>Minute"`
+			Second int `xml:"Time>Second"`
+		} `xml:"Body>GetSystemDateAndTimeResponse>SystemDateAndTime>UTCDateTime"`
+	}
+	if err := c.soap(ctx, c.deviceURL, "", `<GetSystemDateAndTime xmlns="http://www.onvif.org/ver10/device/wsdl"/>`, &dt); err != nil || dt.UTC.Year == 0 {
+		return 0
+	}
+	u := dt.UTC
+	camera := time.Date(u.Year, time.Month(u.Month), u.Day, u.Hour, u.Minute, u.Second, 0, time.UTC)
+	return time.Until(camera).Round(time.Second)
+}
+
+// call sends an authenticated SOAP request.
+func (c *ONVIFCamera) call(ctx context.Context, url string, offset time.Duration, body string, result any) error {
+	header := ""
+	if c.Config.Auth.Username != "" {
+		header = usernameToken(c.Config.Auth.Username, c.Config.Auth.Password, time.Now().Add(offset))
+	}
+	return c.soap(ctx, url, header, body, result)
+}
+
+func (c *ONVIFCamera) soap(ctx context.Context, url, header, body string, result any) error {
+	envelope := `<?xml version="1.0" encoding="UTF-8"?>` +
+		`<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope">` +
+		`<s:Header>` + header + `</s:Header><s:Body>` + body + `</s:Body></s:Envelope>`
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, strings.NewReader(envelope))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/soap+xml; charset=utf-8")
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("error reading response: %w", err)
+	}
+
+	var fault struct {
+		Reason  string `xml:"Body>Fault>Reason>Text"`
+		Subcode string `xml:"Body>Fault>Code>Subcode>Value"`
+	}
+	if xml.Unmarshal(data, &fault) == nil && (fault.Reason != "" || fault.Subcode != "") {
+		return fmt.Errorf("soap fault: %s %s", strings.TrimSpace(fault.Subcode), strings.TrimSpace(fault.Reason))
+	}
+	if resp.StatusCode != http.StatusOK {
+		return &StatusError{Code: resp.StatusCode}
+	}
+	if err := xml.Unmarshal(data, result); err != nil {
+		return fmt.Errorf("error parsing response: %w", err)
+	}
+	return nil
+}
+
+// usernameToken returns a WS-Security header with a password digest:
+// Base64(SHA1(nonce + created + password)).
+func usernameToken(username, password string, now time.Time) string {
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+	created := now.UTC().Format("2006-01-02T15:04:05.000Z")
+
+	h := sha1.New()
+	h.Write(nonce)
+	h.Write([]byte(created))
+	h.Write([]byte(password))
+	digest := base64.StdEncoding.EncodeToString(h.Sum(nil))
+
+	return `<Security s:mustUnderstand="1" xmlns="http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-wssecurity-secext-1.0.xsd">` +
+		`<UsernameToken><Username>` + xmlEscape(username) + `</Username>` +
+		`<Password Type="http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-username-token-profile-1.0#PasswordDigest">` + digest + `</Password>` +
+		`<Nonce EncodingType="http://docs.oasis-open.org/wss/2004/01/oasis-200401-soap-message-security-1.0#Base64Binary">` + base64.StdEncoding.EncodeToString(nonce) + `</Nonce>` +
+		`<Created xmlns="http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-wssecurity-utility-1.0.xsd">` + created + `</Created>` +
+		`</UsernameToken></Security>`
+}
+
+func xmlEscape(s string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
@@ -0,0 +1,217 @@
+package camera
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stone/timelapser/internal/config"
+)
+
+// onvifStandIn answers the ONVIF calls used for snapshot discovery and serves
+// the snapshot itself.
+type onvifStandIn struct {
+	t           *testing.T
+	server      *httptest.Server
+	password    string
+	soapCalls   atomic.Int32
+	snapshotErr atomic.Int32 // status code for snapshot requests, 0 for the image
+}
+
+func newONVIFStandIn(t *testing.T, password string) *onvifStandIn {
+	s := &onvifStandIn{t: t, password: password}
+	s.server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	t.Cleanup(s.server.Close)
+	return s
+}
+
+func (s *onvifStandIn) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/snap.jpg" {
+		if code := s.snapshotErr.Load(); code != 0 {
+			w.WriteHeader(int(code))
+			return
+		}
+		w.Write([]byte("onvif image"))
+		return
+	}
+
+	s.soapCalls.Add(1)
+	body, _ := io.ReadAll(r.Body)
+	var req struct {
+		Username string `xml:"Header>Security>UsernameToken>Username"`
+		Password string `xml:"Header>Security>UsernameToken>Password"`
+		Nonce    string `xml:"Header>Security>UsernameToken>Nonce"`
+		Created  string `xml:"Header>Security>UsernameToken>Created"`
+		Body     struct {
+			Inner struct {
+				XMLName      xml.Name
+				ProfileToken string `xml:"ProfileToken"`
+			} `xml:",any"`
+		} `xml:"Body"`
+	}
+	if err := xml.Unmarshal(body, &req); err != nil {
+		s.t.Errorf("invalid soap request: %v", err)
+		return
+	}
+
+	action := req.Body.Inner.XMLName.Local
+	if action != "GetSystemDateAndTime" && !s.authorized(req.Username, req.Password, req.Nonce, req.Created) {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, envelope(`<s:Fault><s:Code><s:Value>s:Sender</s:Value><s:Subcode><s:Value>ter:NotAuthorized</s:Value></s:Subcode></s:Code><s:Reason><s:Text xml:lang="en">Sender not Authorized</s:Text></s:Reason></s:Fault>`))
+		return
+	}
+
+	switch action {
+	case "GetSystemDateAndTime":
+		now := time.Now().UTC()
+		fmt.Fprint(w, envelope(fmt.Sprintf(`<tds:GetSystemDateAndTimeResponse><tds:SystemDateAndTime><tt:UTCDateTime>`+
+			`<tt:Time><tt:Hour>%d</tt:Hour><tt:Minute>%d</tt:Minute><tt:Second>%d</tt:Second></tt:Time>`+
+			`<tt:Date><tt:Year>%d</tt:Year><tt:Month>%d</tt:Month><tt:Day>%d</tt:Day></tt:Date>`+
+			`</tt:UTCDateTime></tds:SystemDateAndTime></tds:GetSystemDateAndTimeResponse>`,
+			now.Hour(), now.Minute(), now.Second(), now.Year(), now.Month(), now.Day())))
+	case "GetCapabilities":
+		fmt.Fprint(w, envelope(`<tds:GetCapabilitiesResponse><tds:Capabilities><tt:Media><tt:XAddr>`+
+			s.server.URL+`/onvif/media_service</tt:XAddr></tt:Media></tds:Capabilities></tds:GetCapabilitiesResponse>`))
+	case "GetProfiles":
+		fmt.Fprint(w, envelope(`<trt:GetProfilesResponse><trt:Profiles token="main"><tt:Name>Main</tt:Name></trt:Profiles>`+
+			`<trt:Profiles token="sub"><tt:Name>Sub</tt:Name></trt:Profiles></trt:GetProfilesResponse>`))
+	case "GetSnapshotUri":
+		fmt.Fprint(w, envelope(`<trt:GetSnapshotUriResponse><trt:MediaUri><tt:Uri>`+
+			s.server.URL+`/snap.jpg?profile=`+req.Body.Inner.ProfileToken+`</tt:Uri></trt:MediaUri></trt:GetSnapshotUriResponse>`))
+	default:
+		s.t.Errorf("unexpected soap action %q", action)
+	}
+}
+
+func (s *onvifStandIn) authorized(username, password, nonce, created string) bool {
+	rawNonce, err := base64.StdEncoding.DecodeString(nonce)
+	if err != nil || username != "admin" {
+		return false
+	}
+	h := sha1.New()
+	h.Write(rawNonce)
+	h.Write([]byte(created))
+	h.Write([]byte(s.password))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil)) == password
+}
+
+func envelope(body string) string {
+	return `<?xml version="1.0" encoding="UTF-8"?><s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope" ` +
+		`xmlns:tds="http://www.onvif.org/ver10/device/wsdl" xmlns:trt="http://www.onvif.org/ver10/media/wsdl" ` +
+		`xmlns:tt="http://www.onvif.org/ver10/schema" xmlns:ter="http://www.onvif.org/ver10/error">` +
+		`<s:Body>` + body + `</s:Body></s:Envelope>`
+}
+
+func TestONVIFCamera_Fetch(t *testing.T) {
+	standIn := newONVIFStandIn(t, "s3cr<t")
+	camera, err := NewONVIFCamera(config.CameraConfig{
+		ONVIF: config.ONVIFConfig{Host: strings.TrimPrefix(standIn.server.URL, "http://")},
+		Auth:  config.AuthConfig{Username: "admin", Password: "s3cr<t"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for range 2 {
+		frame, err := camera.Fetch(context.Background())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		data := frameData(t, frame)
+		if string(data) != "onvif image" {
+			t.Errorf("data = %q, want %q", data, "onvif image")
+		}
+	}
+	// GetSystemDateAndTime, GetCapabilities, GetProfiles, GetSnapshotUri once
+	if got := standIn.soapCalls.Load(); got != 4 {
+		t.Errorf("soap calls = %d, want 4 (uri cached)", got)
+	}
+	if got := camera.snapshot.Config.SnapshotURL; !strings.HasSuffix(got, "/snap.jpg?profile=main") {
+		t.Errorf("snapshot url = %q, want first profile", got)
+	}
+
+	// A transient server error keeps the cached URI.
+	standIn.snapshotErr.Store(http.StatusServiceUnavailable)
+	if _, err := camera.Fetch(context.Background()); err == nil {
+		t.Fatal("expected error")
+	}
+	standIn.snapshotErr.Store(0)
+	if _, err := camera.Fetch(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := standIn.soapCalls.Load(); got != 4 {
+		t.Errorf("soap calls = %d, want 4 after a 503", got)
+	}
+
+	// An unknown snapshot URI is discovered again on the next fetch.
+	standIn.snapshotErr.Store(http.StatusNotFound)
+	if _, err := camera.Fetch(context.Background()); err == nil {
+		t.Fatal("expected error")
+	}
+	standIn.snapshotErr.Store(0)
+	if _, err := camera.Fetch(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := standIn.soapCalls.Load(); got != 8 {
+		t.Errorf("soap calls = %d, want 8 after rediscovery", got)
+	}
+}
+
+func TestONVIFCamera_discover(t *testing.T) {
+	standIn := newONVIFStandIn(t, "secret")
+
+	tests := []struct {
+		name          string
+		config        config.CameraConfig
+		expectedURI   string
+		expectedError string
+	}{
+		{
+			name: "configured profile and device url",
+			config: config.CameraConfig{
+				ONVIF: config.ONVIFConfig{Host: standIn.server.URL + "/onvif/device_service", Profile: "sub"},
+				Auth:  config.AuthConfig{Username: "admin", Password: "secret"},
+			},
+			expectedURI: standIn.server.URL + "/snap.jpg?profile=sub",
+		},
+		{
+			name: "wrong password",
+			config: config.CameraConfig{
+				ONVIF: config.ONVIFConfig{Host: standIn.server.URL},
+				Auth:  config.AuthConfig{Username: "admin", Password: "wrong"},
+			},
+			expectedError: "GetCapabilities: soap fault: ter:NotAuthorized Sender not Authorized",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			camera, err := NewONVIFCamera(tt.config)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			uri, err := camera.discover(context.Background())
+			if tt.expectedError != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.expectedError) {
+					t.Errorf("error = %v, want %v", err, tt.expectedError)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if uri != tt.expectedURI {
+				t.Errorf("uri = %q, want %q", uri, tt.expectedURI)
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package camera
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stone/timelapser/internal/config"
+)
+
+func TestCamera_fetchRetry(t *testing.T) {
+	tests := []struct {
+		name             string
+		failures         int32
+		failStatus       int
+		hang             bool
+		retries          int
+		expectedAttempts int32
+		expectedError    string
+	}{
+		{
+			name:             "recovers after server errors",
+			failures:         2,
+			failStatus:       http.StatusServiceUnavailable,
+			retries:          2,
+			expectedAttempts: 3,
+		},
+		{
+			name:             "gives up after retries",
+			failures:         5,
+			failStatus:       http.StatusBadGateway,
+			retries:          2,
+			expectedAttempts: 3,
+			expectedError:    "unexpected status code: 502 (after 3 attempts)",
+		},
+		{
+			name:             "client errors are not retried",
+			failures:         5,
+			failStatus:       http.StatusNotFound,
+			retries:          2,
+			expectedAttempts: 1,
+			expectedError:    "unexpected status code: 404",
+		},
+		{
+			name:             "hung camera times out",
+			failures:         5,
+			hang:             true,
+			retries:          1,
+			expectedAttempts: 2,
+			expectedError:    "context deadline exceeded",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var attempts atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if attempts.Add(1) <= tt.failures {
+					if tt.hang {
+						<-r.Context().Done()
+						return
+					}
+					w.WriteHeader(tt.failStatus)
+					return
+				}
+				w.Write([]byte("image data"))
+			}))
+			defer server.Close()
+
+			camera := mustNewCamera(t, config.CameraConfig{
+				SnapshotURL:  server.URL,
+				Timeout:      100 * time.Millisecond,
+				Retries:      tt.retries,
+				RetryBackoff: time.Millisecond,
+			})
+
+			frame, err := camera.Fetch(context.Background())
+
+			if got := attempts.Load(); got != tt.expectedAttempts {
+				t.Errorf("attempts = %d, want %d", got, tt.expectedAttempts)
+			}
+			if tt.expectedError != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.expectedError) {
+					t.Errorf("error = %v, want %v", err, tt.expectedError)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			data := frameData(t, frame)
+			if string(data) != "image data" {
+				t.Errorf("data = %q, want %q", data, "image data")
+			}
+		})
+	}
+}
+
+func TestCamera_fetchCancelled(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	camera := mustNewCamera(t, config.CameraConfig{
+		SnapshotURL:  server.URL,
+		Retries:      10,
+		RetryBackoff: time.Hour,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := camera.Fetch(ctx)
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) {
+		t.Errorf("error = %v, want StatusError", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("fetch took %v, cancellation was not honoured during backoff", elapsed)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	base := 100 * time.Millisecond
+	for attempt := 0; attempt < 12; attempt++ {
+		d := retryDelay(base, attempt)
+		upper := min(base<<attempt, maxRetryBackoff)
+		if d < upper/2 || d > upper {
+			t.Errorf("retryDelay(%v, %d) = %v, want between %v and %v", base, attempt, d, upper/2, upper)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package camera
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/stone/timelapser/internal/config"
+)
+
+// defaultRTSPTimeout bounds how long ffmpeg may spend connecting to the
+// stream and decoding the first frame when no camera timeout is set.
+const defaultRTSPTimeout = 30 * time.Second
+
+// FrameGrabber interface for mocking ffmpeg in tests
+type FrameGrabber interface {
+	Grab(ctx context.Context, args []string) ([]byte, error)
+}
+
+// ffmpegGrabber runs ffmpeg directly (no shell) and returns what it writes
+// to stdout, up to maxSize bytes.
+type ffmpegGrabber struct {
+	maxSize int64
+}
+
+func (g ffmpegGrabber) Grab(ctx context.Context, args []string) ([]byte, error) {
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		return nil, fmt.Errorf("ffmpeg not found in PATH: %w", err)
+	}
+
+	stdout := &limitedBuffer{limit: g.maxSize}
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	cmd.Stdout = stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	// ffmpeg usually dies of a broken pipe once the output is cut off.
+	if stdout.exceeded {
+		return nil, tooLarge(stdout.limit)
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("ffmpeg timed out: %w", ctx.Err())
+		}
+		return nil, fmt.Errorf("ffmpeg execution failed: %w (%s)", err, strings.TrimSpace(stderr.String()))
+	}
+
+	return stdout.Bytes(), nil
+}
+
+func init() {
+	Register("rtsp", func(cfg config.CameraConfig) (Source, error) {
+		return NewRTSPCamera(cfg), nil
+	})
+}
+
+// RTSPCamera grabs a single JPEG frame from an RTSP stream.
+type RTSPCamera struct {
+	Config  config.CameraConfig
+	Grabber FrameGrabber
+}
+
+func NewRTSPCamera(cfg config.CameraConfig) *RTSPCamera {
+	return &RTSPCamera{Config: cfg, Grabber: ffmpegGrabber{maxSize: maxSize(cfg)}}
+}
+
+func (c *RTSPCamera) Fetch(ctx context.Context) (*Frame, error) {
+	args, err := c.buildArgs()
+	if err != nil {
+		return nil, fmt.Errorf("error preparing ffmpeg arguments: %v", err)
+	}
+
+	timeout := c.Config.Timeout
+	if timeout <= 0 {
+		timeout = defaultRTSPTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	release, err := acquireHost(ctx, Host(c.Config))
+	if err != nil {
+		return nil, fmt.Errorf("error grabbing frame: %v", err)
+	}
+	defer release()
+
+	limit := maxSize(c.Config)
+	data, err := c.Grabber.Grab(ctx, args)
+	if errors.Is(err, ErrTooLarge) {
+		return nil, fmt.Errorf("error grabbing frame: %w", err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error grabbing frame: %s", c.redact(err.Error()))
+	}
+	if len(data) == 0 {
+		return nil, errors.New("error grabbing frame: no data received from stream")
+	}
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("error grabbing frame: %w", tooLarge(limit))
+	}
+
+	return &Frame{Data: data, ContentType: "image/jpeg", Time: time.Now()}, nil
+}
+
+// redact removes the stream password from ffmpeg output, which tends to echo
+// the input URL including credentials.
+func (c *RTSPCamera) redact(s string) string {
+	passwords := []string{c.Config.Auth.Password}
+	if u, err := url.Parse(c.Config.RTSP.URL); err == nil && u.User != nil {
+		if p, ok := u.User.Password(); ok {
+			passwords = append(passwords, p)
+		}
+	}
+	for _, p := range passwords {
+		if p == "" {
+			continue
+		}
+		// as written into the URL, e.g. p%40ss for p@ss
+		escaped := strings.TrimPrefix(url.UserPassword("", p).String(), ":")
+		s = strings.ReplaceAll(s, escaped, "xxxxx")
+		s = strings.ReplaceAll(s, p, "xxxxx")
+	}
+	return s
+}
+
+// buildArgs returns the ffmpeg arguments that read one frame from the stream
+// and write it as JPEG to stdout. Credentials from the auth section are added
+// to the URL unless it already carries its own.
+func (c *RTSPCamera) buildArgs() ([]string, error) {
+	u, err := url.Parse(c.Config.RTSP.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid rtsp url: %w", err)
+	}
+	if u.Scheme != "rtsp" && u.Scheme != "rtsps" {
+		return nil, fmt.Errorf("unsupported rtsp url scheme %q", u.Scheme)
+	}
+
+	if u.User == nil && c.Config.Auth.Username != "" {
+		u.User = url.UserPassword(c.Config.Auth.Username, c.Config.Auth.Password)
+	}
+
+	transport := c.Config.RTSP.Transport
+	switch transport {
+	case "":
+		transport = "tcp"
+	case "tcp", "udp":
+	default:
+		return nil, fmt.Errorf("unsupported rtsp transport %q", transport)
+	}
+
+	return []string{
+		"-hide_banner",
+		"-loglevel", "error",
+		"-rtsp_transport", transport,
+		"-i", u.String(),
+		"-frames:v", "1",
+		"-f", "image2pipe",
+		"-c:v", "mjpeg",
+		"-",
+	}, nil
+}
@@ -0,0 +1,221 @@
+package camera
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stone/timelapser/internal/config"
+)
+
+// Frame is a single image returned by a Source. The image is either held in
+// Data or, for images downloaded over HTTP, in a temporary file; Reader
+// returns it in both cases. The caller must Close the frame.
+type Frame struct {
+	Data        []byte
+	ContentType string    // MIME type reported by the source, may be empty
+	Time        time.Time // When the frame was taken, names the stored snapshot
+
+	file       *os.File   // temporary file holding the image instead of Data, see spool
+	size       int64      // size of file
+	conditions conditions // validators of the response, saved by Camera.Ack
+}
+
+// Reader returns the image and its size.
+func (f *Frame) Reader() (io.ReaderAt, int64) {
+	if f.file != nil {
+		return f.file, f.size
+	}
+	return bytes.NewReader(f.Data), int64(len(f.Data))
+}
+
+// File returns the path of the temporary file holding the image, if any. The
+// file may be moved away, e.g. into the storage; Close then only closes it.
+func (f *Frame) File() (string, bool) {
+	if f.file == nil {
+		return "", false
+	}
+	return f.file.Name(), true
+}
+
+// Close removes the temporary file of the frame, if any.
+func (f *Frame) Close() error {
+	if f.file == nil {
+		return nil
+	}
+	f.file.Close()
+	if err := os.Remove(f.file.Name()); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+type spoolDirKey struct{}
+
+// WithSpoolDir returns a context that makes sources download images into
+// temporary files in dir instead of the default temporary directory, e.g. to
+// rename them into the storage afterwards.
+func WithSpoolDir(ctx context.Context, dir string) context.Context {
+	return context.WithValue(ctx, spoolDirKey{}, dir)
+}
+
+// spool copies r to a temporary file, failing as soon as more than limit
+// bytes arrive, so that a snapshot is never held in memory while it is
+// downloaded.
+func spool(ctx context.Context, r io.Reader, limit int64) (*Frame, error) {
+	dir, _ := ctx.Value(spoolDirKey{}).(string)
+	f, err := os.CreateTemp(dir, ".snapshot-*.tmp")
+	if err != nil {
+		return nil, fmt.Errorf("error creating temp file: %w", err)
+	}
+	frame := &Frame{file: f}
+	n, err := io.Copy(f, io.LimitReader(r, limit+1))
+	if err == nil && n > limit {
+		err = tooLarge(limit)
+	}
+	if err != nil {
+		frame.Close()
+		return nil, err
+	}
+	frame.size = n
+	return frame, nil
+}
+
+// ErrNotModified is returned by Fetch when the camera reports that the image
+// has not changed since the previous fetch. It means there is no new frame to
+// store, not that the camera failed.
+var ErrNotModified = errors.New("snapshot not modified")
+
+// ErrTooLarge is returned when a snapshot exceeds the camera's maxSize, e.g.
+// because the URL points at a video instead of an image.
+var ErrTooLarge = errors.New("snapshot too large")
+
+// defaultMaxSize applies when a camera has no maxSize, as in configurations
+// not loaded through config.LoadConfig.
+const defaultMaxSize = 20 << 20
+
+func maxSize(cfg config.CameraConfig) int64 {
+	if cfg.MaxSize > 0 {
+		return cfg.MaxSize
+	}
+	return defaultMaxSize
+}
+
+// tooLarge describes a snapshot over the size limit.
+func tooLarge(limit int64) error {
+	return fmt.Errorf("%w: more than %d bytes (maxSize)", ErrTooLarge, limit)
+}
+
+// readLimited reads r to the end, failing as soon as more than limit bytes
+// arrive instead of buffering an unbounded body.
+func readLimited(r io.Reader, limit int64) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, tooLarge(limit)
+	}
+	return data, nil
+}
+
+// readFileLimited reads the file at path unless it is larger than limit.
+func readFileLimited(path string, limit int64) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readLimited(f, limit)
+}
+
+// Source produces snapshot images for a camera. A Source is created once per
+// camera and reused for every scheduled snapshot.
+type Source interface {
+	Fetch(ctx context.Context) (*Frame, error)
+}
+
+// Acknowledger is implemented by sources that need to know when a frame has
+// been handled, e.g. to remove the file it was read from. Ack is called after
+// the frame was stored or skipped as a duplicate, not when it was rejected.
+type Acknowledger interface {
+	Ack(frame *Frame) error
+}
+
+// Factory creates a Source from a camera configuration.
+type Factory func(cfg config.CameraConfig) (Source, error)
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]Factory)
+)
+
+// Register makes a source available under the camera type name typ.
+// It panics if typ is registered twice.
+func Register(typ string, factory Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, exists := registry[typ]; exists {
+		panic("camera: source type registered twice: " + typ)
+	}
+	registry[typ] = factory
+}
+
+// Types returns the registered camera type names in sorted order.
+func Types() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	types := make([]string, 0, len(registry))
+	for typ := range registry {
+		types = append(types, typ)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// NewSource creates the Source for cfg.Type. An empty type means http.
+func NewSource(cfg config.CameraConfig) (Source, error) {
+	typ := cfg.Type
+	if typ == "" {
+		typ = "http"
+	}
+
+	registryMu.RLock()
+	factory, ok := registry[typ]
+	registryMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown camera type %q (available: %v)", typ, Types())
+	}
+
+	return factory(cfg)
+}
+
+// Host returns the host name a camera is captured from, used to limit the
+// load on devices serving several cameras. It is empty for local sources.
+func Host(cfg config.CameraConfig) string {
+	raw := cfg.SnapshotURL
+	switch cfg.Type {
+	case "rtsp":
+		raw = cfg.RTSP.URL
+	case "onvif":
+		raw = cfg.ONVIF.Host
+		if !strings.Contains(raw, "://") {
+			raw = "http://" + raw
+		}
+	case "file", "exec":
+		return ""
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return ""
+	}
+	return u.Hostname()
+}
@@ -0,0 +1,54 @@
+package camera
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stone/timelapser/internal/config"
+)
+
+type staticSource struct{}
+
+func (staticSource) Fetch(context.Context) (*Frame, error) {
+	return &Frame{Data: []byte("static")}, nil
+}
+
+func TestNewSource(t *testing.T) {
+	Register("test-static", func(config.CameraConfig) (Source, error) {
+		return staticSource{}, nil
+	})
+
+	tests := []struct {
+		name          string
+		typ           string
+		expectedType  string
+		expectedError string
+	}{
+		{name: "default is http", typ: "", expectedType: "*camera.Camera"},
+		{name: "http", typ: "http", expectedType: "*camera.Camera"},
+		{name: "rtsp", typ: "rtsp", expectedType: "*camera.RTSPCamera"},
+		{name: "registered type", typ: "test-static", expectedType: "camera.staticSource"},
+		{name: "unknown type", typ: "carrier-pigeon", expectedError: `unknown camera type "carrier-pigeon"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, err := NewSource(config.CameraConfig{Type: tt.typ})
+
+			if tt.expectedError != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.expectedError) {
+					t.Errorf("error = %v, want %v", err, tt.expectedError)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := fmt.Sprintf("%T", src); got != tt.expectedType {
+				t.Errorf("source type = %s, want %s", got, tt.expectedType)
+			}
+		})
+	}
+}
@@ -0,0 +1,194 @@
+package camera
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stone/timelapser/internal/config"
+)
+
+// testPKI is a throwaway internal CA with a server and a client certificate.
+type testPKI struct {
+	caFile, certFile, keyFile string
+	caPool                    *x509.CertPool
+	server                    tls.Certificate
+}
+
+func newTestPKI(t *testing.T) *testPKI {
+	t.Helper()
+	dir := t.TempDir()
+
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test Internal CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("creating CA: %v", err)
+	}
+	caCert, _ := x509.ParseCertificate(caDER)
+
+	issue := func(serial int64, usage x509.ExtKeyUsage, dnsNames ...string) ([]byte, *ecdsa.PrivateKey) {
+		key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: "test"},
+			DNSNames:     dnsNames,
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caKey)
+		if err != nil {
+			t.Fatalf("issuing certificate: %v", err)
+		}
+		return der, key
+	}
+
+	writePEM := func(name, typ string, der []byte) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0o600); err != nil {
+			t.Fatalf("writing %s: %v", name, err)
+		}
+		return path
+	}
+
+	serverDER, serverKey := issue(2, x509.ExtKeyUsageServerAuth, "camera.internal")
+	clientDER, clientKey := issue(3, x509.ExtKeyUsageClientAuth)
+	clientKeyDER, _ := x509.MarshalECPrivateKey(clientKey)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(caCert)
+
+	return &testPKI{
+		caFile:   writePEM("ca.pem", "CERTIFICATE", caDER),
+		certFile: writePEM("client.pem", "CERTIFICATE", clientDER),
+		keyFile:  writePEM("client-key.pem", "EC PRIVATE KEY", clientKeyDER),
+		caPool:   pool,
+		server:   tls.Certificate{Certificate: [][]byte{serverDER}, PrivateKey: serverKey},
+	}
+}
+
+func TestCamera_fetchTLS(t *testing.T) {
+	pki := newTestPKI(t)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("image data"))
+	}))
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{pki.server},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pki.caPool,
+		MaxVersion:   tls.VersionTLS12,
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	valid := config.TLSConfig{
+		CAFile:     pki.caFile,
+		CertFile:   pki.certFile,
+		KeyFile:    pki.keyFile,
+		ServerName: "camera.internal",
+	}
+
+	tests := []struct {
+		name          string
+		tls           config.TLSConfig
+		expectedError string
+	}{
+		{
+			name: "mutual tls with internal ca",
+			tls:  valid,
+		},
+		{
+			name:          "untrusted server without ca file",
+			tls:           config.TLSConfig{CertFile: pki.certFile, KeyFile: pki.keyFile, ServerName: "camera.internal"},
+			expectedError: "certificate signed by unknown authority",
+		},
+		{
+			name:          "server name mismatch",
+			tls:           config.TLSConfig{CAFile: pki.caFile, CertFile: pki.certFile, KeyFile: pki.keyFile},
+			expectedError: "doesn't contain any IP SANs",
+		},
+		{
+			name:          "missing client certificate",
+			tls:           config.TLSConfig{CAFile: pki.caFile, ServerName: "camera.internal"},
+			expectedError: "error making request",
+		},
+		{
+			name: "minimum version not offered by server",
+			tls: config.TLSConfig{
+				CAFile: pki.caFile, CertFile: pki.certFile, KeyFile: pki.keyFile,
+				ServerName: "camera.internal", MinVersion: "1.3",
+			},
+			expectedError: "protocol version",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			camera := mustNewCamera(t, config.CameraConfig{SnapshotURL: server.URL, TLS: tt.tls})
+
+			frame, err := camera.Fetch(context.Background())
+
+			if tt.expectedError != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.expectedError) {
+					t.Errorf("error = %v, want %v", err, tt.expectedError)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			data := frameData(t, frame)
+			if string(data) != "image data" {
+				t.Errorf("data = %q, want %q", data, "image data")
+			}
+		})
+	}
+}
+
+func TestNewCamera_invalidTLS(t *testing.T) {
+	pki := newTestPKI(t)
+
+	tests := []struct {
+		name          string
+		tls           config.TLSConfig
+		expectedError string
+	}{
+		{name: "missing ca file", tls: config.TLSConfig{CAFile: "/nonexistent/ca.pem"}, expectedError: "reading CA file"},
+		{name: "ca file without certificates", tls: config.TLSConfig{CAFile: pki.keyFile}, expectedError: "no certificates found"},
+		{name: "cert without key", tls: config.TLSConfig{CertFile: pki.certFile}, expectedError: "must be set together"},
+		{name: "unknown version", tls: config.TLSConfig{MinVersion: "1.4"}, expectedError: "unsupported tls minVersion"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewCamera(config.CameraConfig{TLS: tt.tls})
+			if err == nil || !strings.Contains(err.Error(), tt.expectedError) {
+				t.Errorf("error = %v, want %v", err, tt.expectedError)
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package camera
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/stone/timelapser/internal/config"
+	"github.com/stone/timelapser/internal/hostlimit"
+)
+
+// transportKey holds the camera settings a transport depends on. Cameras with
+// equal keys share a transport and with it the keep-alive connections to
+// their host, e.g. all channels of an NVR.
+type transportKey struct {
+	insecure       bool
+	tls            config.TLSConfig
+	proxy          string
+	connectTimeout time.Duration
+}
+
+var (
+	transportsMu sync.Mutex
+	transports   = make(map[transportKey]*http.Transport)
+
+	hostLimitsMu sync.RWMutex
+	hostLimits   *hostlimit.Limits // nil means unlimited
+)
+
+// SetHostLimit limits the requests and RTSP grabs of all cameras per host.
+func SetHostLimit(cfg config.HostLimitConfig) {
+	hostLimitsMu.Lock()
+	defer hostLimitsMu.Unlock()
+	hostLimits = hostlimit.New(cfg)
+}
+
+// acquireHost waits until a request to host may start, see SetHostLimit.
+func acquireHost(ctx context.Context, host string) (func(), error) {
+	hostLimitsMu.RLock()
+	limits := hostLimits
+	hostLimitsMu.RUnlock()
+	return limits.Acquire(ctx, host)
+}
+
+// sharedTransport returns the transport for cfg, creating it on first use.
+func sharedTransport(cfg config.CameraConfig) (*http.Transport, error) {
+	key := transportKey{insecure: cfg.Insecure, tls: cfg.TLS, proxy: cfg.Proxy, connectTimeout: cfg.ConnectTimeout}
+
+	transportsMu.Lock()
+	defer transportsMu.Unlock()
+	if transport, ok := transports[key]; ok {
+		return transport, nil
+	}
+	transport, err := newTransport(cfg)
+	if err != nil {
+		return nil, err
+	}
+	transports[key] = transport
+	return transport, nil
+}
+
+func newTransport(cfg config.CameraConfig) (*http.Transport, error) {
+	tlsConfig, err := newTLSConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	proxy := http.ProxyFromEnvironment
+	if cfg.Proxy != "" {
+		proxyURL, err := url.Parse(cfg.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy url: %w", err)
+		}
+		switch proxyURL.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			return nil, fmt.Errorf("unsupported proxy scheme %q", proxyURL.Scheme)
+		}
+		proxy = http.ProxyURL(proxyURL)
+	}
+
+	dialer := &net.Dialer{Timeout: cfg.ConnectTimeout, KeepAlive: 30 * time.Second}
+	return &http.Transport{
+		Proxy:               proxy,
+		DialContext:         dialer.DialContext,
+		TLSHandshakeTimeout: cfg.ConnectTimeout,
+		TLSClientConfig:     tlsConfig,
+		MaxIdleConnsPerHost: 16,
+		IdleConnTimeout:     90 * time.Second,
+		ForceAttemptHTTP2:   true,
+	}, nil
+}
+
+// limitedTransport applies the host limit to every request, including login,
+// token and retry requests. A request counts as running until its response
+// body is closed.
+type limitedTransport struct {
+	next http.RoundTripper
+}
+
+func (t limitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	release, err := acquireHost(req.Context(), req.URL.Hostname())
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	resp.Body = &releaseBody{ReadCloser: resp.Body, release: release}
+	return resp, nil
+}
+
+type releaseBody struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (b *releaseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
@@ -0,0 +1,59 @@
+package camera
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stone/timelapser/internal/config"
+)
+
+func TestSharedTransport(t *testing.T) {
+	first := mustNewCamera(t, config.CameraConfig{Name: "nvr channel 1", SnapshotURL: "http://nvr.local/1", ConnectTimeout: 3 * time.Second})
+	second := mustNewCamera(t, config.CameraConfig{Name: "nvr channel 2", SnapshotURL: "http://nvr.local/2", ConnectTimeout: 3 * time.Second})
+	other := mustNewCamera(t, config.CameraConfig{Name: "insecure", SnapshotURL: "https://cam.local/", ConnectTimeout: 3 * time.Second, Insecure: true})
+
+	transport := func(c *Camera) http.RoundTripper {
+		return c.Client.(*http.Client).Transport.(limitedTransport).next
+	}
+	if transport(first) != transport(second) {
+		t.Error("cameras with the same settings do not share a transport")
+	}
+	if transport(first) == transport(other) {
+		t.Error("cameras with different TLS settings share a transport")
+	}
+}
+
+func TestLimitedTransport(t *testing.T) {
+	SetHostLimit(config.HostLimitConfig{Concurrency: 1})
+	defer SetHostLimit(config.HostLimitConfig{})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+	client := &http.Client{Transport: limitedTransport{next: http.DefaultTransport}}
+
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The first response body is still open, so the host is busy.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+	if _, err := client.Do(req); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("second request error = %v, want deadline exceeded", err)
+	}
+
+	resp.Body.Close()
+	resp, err = client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("after close: unexpected error: %v", err)
+	}
+	resp.Body.Close()
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log/slog"
 	"os"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
@@ -14,35 +15,212 @@ const (
 	defaultTimelapseInterval = "0 * * * *"
 	defaultFrameDuration     = 0.0416667
 	defaultFFmpegTemplate    = "ffmpeg -f concat -safe 0 -i {{.ListPath}} -vf fps=24,format=yuv420p -c:v libx264 -preset medium -crf 23 -movflags +faststart -y {{.OutputPath}}"
+	defaultTimeout           = 30 * time.Second
+	defaultConnectTimeout    = 10 * time.Second
+	defaultRetries           = 2
+	defaultRetryBackoff      = time.Second
+	defaultConcurrency       = 8
+	defaultMaxSize           = 20 << 20
 )
 
+// LoginConfig describes a web form login that yields a session cookie
+type LoginConfig struct {
+	URL           string            `yaml:"url,omitempty"`           // where the login form is posted
+	Page          string            `yaml:"page,omitempty"`          // login page the camera redirects to when the session expired, defaults to url
+	UsernameField string            `yaml:"usernameField,omitempty"` // default "username"
+	PasswordField string            `yaml:"passwordField,omitempty"` // default "password"
+	Fields        map[string]string `yaml:"fields,omitempty"`        // additional form fields
+	SuccessStatus int               `yaml:"successStatus,omitempty"` // expected status code, default any 2xx
+	SuccessCookie string            `yaml:"successCookie,omitempty"` // cookie that must be set by a successful login
+}
+
+// OAuth2Config configures the OAuth2 client credentials grant
+type OAuth2Config struct {
+	TokenURL         string   `yaml:"tokenUrl,omitempty"`
+	ClientID         string   `yaml:"clientId,omitempty"`
+	ClientSecret     string   `yaml:"clientSecret,omitempty"`
+	ClientSecretFile string   `yaml:"clientSecretFile,omitempty"` // read clientSecret from this file
+	Scopes           []string `yaml:"scopes,omitempty"`
+	AuthStyle        string   `yaml:"authStyle,omitempty"` // header (default, HTTP basic) or params (in the form body)
+}
+
 type AuthConfig struct {
-	Type     string `yaml:"type,omitempty"`     // basic, bearer
-	Username string `yaml:"username,omitempty"` // for basic auth
-	Password string `yaml:"password,omitempty"` // for basic auth
-	Token    string `yaml:"token,omitempty"`    // for bearer auth
+	Type         string       `yaml:"type,omitempty"`         // basic, bearer, digest, login, oauth2
+	Username     string       `yaml:"username,omitempty"`     // for basic, digest and login auth
+	Password     string       `yaml:"password,omitempty"`     // for basic, digest and login auth
+	PasswordFile string       `yaml:"passwordFile,omitempty"` // read password from this file
+	Token        string       `yaml:"token,omitempty"`        // for bearer auth
+	TokenFile    string       `yaml:"tokenFile,omitempty"`    // read token from this file
+	Login        LoginConfig  `yaml:"login,omitempty"`        // for login auth
+	OAuth2       OAuth2Config `yaml:"oauth2,omitempty"`       // for oauth2 auth
+}
+
+// RTSPConfig configures frame grabbing from an RTSP stream using ffmpeg
+type RTSPConfig struct {
+	URL       string `yaml:"url,omitempty"`       // rtsp:// or rtsps:// stream URL
+	Transport string `yaml:"transport,omitempty"` // tcp (default), udp
+}
+
+// MJPEGConfig limits frame extraction from multipart/x-mixed-replace streams
+type MJPEGConfig struct {
+	MaxFrameSize int64         `yaml:"maxFrameSize,omitempty"` // bytes, default the camera maxSize
+	Timeout      time.Duration `yaml:"timeout,omitempty"`      // wait for the first frame, default 10s
+}
+
+// ONVIFConfig configures snapshot URI discovery for ONVIF cameras
+type ONVIFConfig struct {
+	Host    string `yaml:"host,omitempty"`    // host[:port] or device service URL, default path /onvif/device_service
+	Profile string `yaml:"profile,omitempty"` // media profile token, default the first profile
+}
+
+// FileConfig configures picking up images that other devices drop into a
+// local or mounted directory
+type FileConfig struct {
+	Path       string        `yaml:"path,omitempty"`       // directory to pick up images from, or a fixed file
+	Pattern    string        `yaml:"pattern,omitempty"`    // glob matched against file names in the directory, default any image
+	MinAge     time.Duration `yaml:"minAge,omitempty"`     // skip files modified more recently, e.g. still being copied
+	After      string        `yaml:"after,omitempty"`      // keep (default, newest file only), remove or archive the file once stored (oldest first)
+	ArchiveDir string        `yaml:"archiveDir,omitempty"` // where archived files are moved, default <path>/archive
+}
+
+// ExecConfig configures capturing with an external command that writes the
+// image to stdout or to {{.OutputPath}}
+type ExecConfig struct {
+	Command string `yaml:"command,omitempty"` // e.g. "libcamera-still -n -o -", arguments are templates
+}
+
+// CameraPushConfig configures how a push camera uploads its snapshots
+type CameraPushConfig struct {
+	Path      string `yaml:"path,omitempty"`      // upload path, default /push/<camera>
+	Token     string `yaml:"token,omitempty"`     // sent as bearer token or ?token= query parameter
+	TokenFile string `yaml:"tokenFile,omitempty"` // read token from this file
+}
+
+// PushConfig configures the HTTP listener receiving uploads from push cameras
+type PushConfig struct {
+	Listen  string `yaml:"listen,omitempty"`  // address, e.g. ":8080"
+	MaxSize int64  `yaml:"maxSize,omitempty"` // largest accepted upload in bytes, default 20 MiB
+}
+
+// CameraFTPConfig configures the FTP login of a camera uploading over FTP
+type CameraFTPConfig struct {
+	Username     string `yaml:"username,omitempty"` // default the camera directory name
+	Password     string `yaml:"password,omitempty"`
+	PasswordFile string `yaml:"passwordFile,omitempty"` // read password from this file
+}
+
+// FTPConfig configures the embedded FTP server receiving uploads from ftp
+// cameras
+type FTPConfig struct {
+	Listen       string `yaml:"listen,omitempty"`       // address, e.g. ":2121"
+	PassivePorts string `yaml:"passivePorts,omitempty"` // port range for data connections, e.g. "30000-30009", default any
+	PublicHost   string `yaml:"publicHost,omitempty"`   // IPv4 address announced for passive mode, default the server address
+	MaxSize      int64  `yaml:"maxSize,omitempty"`      // largest accepted upload in bytes, default 20 MiB
+}
+
+// HostLimitConfig limits the load put on a single camera host, e.g. an NVR
+// serving many channels. Zero means unlimited.
+type HostLimitConfig struct {
+	Concurrency int     `yaml:"concurrency,omitempty"` // requests to the same host at the same time
+	Rate        float64 `yaml:"rate,omitempty"`        // requests per second to the same host
+}
+
+// S3Config configures an S3-compatible object storage, e.g. AWS S3 or MinIO
+type S3Config struct {
+	Endpoint      string        `yaml:"endpoint,omitempty"` // e.g. http://minio:9000, default https://s3.<region>.amazonaws.com
+	Region        string        `yaml:"region,omitempty"`   // default us-east-1
+	Bucket        string        `yaml:"bucket,omitempty"`
+	Prefix        string        `yaml:"prefix,omitempty"` // prepended to all keys, e.g. "site1/"
+	AccessKey     string        `yaml:"accessKey,omitempty"`
+	SecretKey     string        `yaml:"secretKey,omitempty"`
+	SecretKeyFile string        `yaml:"secretKeyFile,omitempty"` // read secretKey from this file
+	PathStyle     bool          `yaml:"pathStyle,omitempty"`     // bucket in the URL path instead of the host name, as MinIO expects
+	Timeout       time.Duration `yaml:"timeout,omitempty"`       // per request, including the upload or download, default 5m
+}
+
+// StorageConfig selects where snapshots and timelapses are stored
+type StorageConfig struct {
+	Type string   `yaml:"type,omitempty"` // local (default, in outputDir) or s3
+	S3   S3Config `yaml:"s3,omitempty"`
+}
+
+// ValidationConfig controls the checks a snapshot must pass before it is
+// stored. Rejected snapshots are moved to the camera's rejected/ directory.
+type ValidationConfig struct {
+	Decode    bool  `yaml:"decode,omitempty"`    // fully decode the image instead of only the header
+	MinWidth  int   `yaml:"minWidth,omitempty"`  // pixels
+	MinHeight int   `yaml:"minHeight,omitempty"` // pixels
+	MinSize   int64 `yaml:"minSize,omitempty"`   // bytes
+}
+
+// DedupConfig controls skipping of snapshots that equal the last stored frame
+type DedupConfig struct {
+	Enabled    bool `yaml:"enabled,omitempty"`    // skip byte-identical frames
+	Perceptual bool `yaml:"perceptual,omitempty"` // also skip visually similar frames (JPEG, PNG and GIF)
+	Threshold  int  `yaml:"threshold,omitempty"`  // max differing bits of the 64-bit perceptual hash
+}
+
+// TLSConfig configures certificate verification and client certificates
+type TLSConfig struct {
+	CAFile     string `yaml:"caFile,omitempty"`     // PEM CA bundle trusted in addition to the system roots
+	CertFile   string `yaml:"certFile,omitempty"`   // PEM client certificate for mutual TLS
+	KeyFile    string `yaml:"keyFile,omitempty"`    // PEM client key for mutual TLS
+	MinVersion string `yaml:"minVersion,omitempty"` // 1.0, 1.1, 1.2 or 1.3, default 1.2
+	ServerName string `yaml:"serverName,omitempty"` // name to verify the server certificate against
 }
 
 type CameraConfig struct {
-	Name              string     `yaml:"name"`
-	SnapshotURL       string     `yaml:"snapshotUrl"`
-	Insecure          bool       `yaml:"insecure"`
-	Auth              AuthConfig `yaml:"auth,omitempty"`
-	Delete            bool       `yaml:"delete"`
-	Interval          string     `yaml:"interval,omitempty"`
-	TimelapseInterval string     `yaml:"timelapseInterval,omitempty"`
-	FrameDuration     float64    `yaml:"frameDuration,omitempty"`
-	FFmpegTemplate    string     `yaml:"ffmpeg_template,omitempty"`
+	Name              string            `yaml:"name"`
+	Type              string            `yaml:"type,omitempty"` // http (default), mjpeg, rtsp, onvif, file, exec, push, ftp
+	SnapshotURL       string            `yaml:"snapshotUrl,omitempty"`
+	Method            string            `yaml:"method,omitempty"`  // HTTP method, default GET
+	Headers           map[string]string `yaml:"headers,omitempty"` // values may reference environment variables as ${NAME}
+	Body              string            `yaml:"body,omitempty"`    // request body, e.g. JSON for a capture API
+	Proxy             string            `yaml:"proxy,omitempty"`   // http://, https:// or socks5:// proxy URL
+	MJPEG             MJPEGConfig       `yaml:"mjpeg,omitempty"`
+	RTSP              RTSPConfig        `yaml:"rtsp,omitempty"`
+	ONVIF             ONVIFConfig       `yaml:"onvif,omitempty"`
+	File              FileConfig        `yaml:"file,omitempty"`
+	Exec              ExecConfig        `yaml:"exec,omitempty"`
+	Push              CameraPushConfig  `yaml:"push,omitempty"`
+	FTP               CameraFTPConfig   `yaml:"ftp,omitempty"`
+	Insecure          bool              `yaml:"insecure"`
+	TLS               TLSConfig         `yaml:"tls,omitempty"`
+	Timeout           time.Duration     `yaml:"timeout,omitempty"`        // per attempt, including reading the image; 0 none (rtsp and exec: 30s)
+	ConnectTimeout    time.Duration     `yaml:"connectTimeout,omitempty"` // TCP connect and TLS handshake; 0 none
+	Retries           int               `yaml:"retries,omitempty"`        // retries after transient errors (5xx, connection reset, timeout)
+	RetryBackoff      time.Duration     `yaml:"retryBackoff,omitempty"`   // first retry delay, doubled per attempt with jitter
+	MaxSize           int64             `yaml:"maxSize,omitempty"`        // largest accepted snapshot in bytes; 0 the built-in 20 MiB
+	Layout            string            `yaml:"layout,omitempty"`         // snapshot partitions, e.g. YYYY/MM/DD, default flat
+	Auth              AuthConfig        `yaml:"auth,omitempty"`
+	Validation        ValidationConfig  `yaml:"validation,omitempty"`
+	Dedup             DedupConfig       `yaml:"dedup,omitempty"`
+	Delete            bool              `yaml:"delete"`
+	Interval          string            `yaml:"interval,omitempty"`
+	TimelapseInterval string            `yaml:"timelapseInterval,omitempty"`
+	FrameDuration     float64           `yaml:"frameDuration,omitempty"`
+	FFmpegTemplate    string            `yaml:"ffmpeg_template,omitempty"`
 }
 
 type Config struct {
-	OutputDir         string         `yaml:"outputDir"`
-	Cameras           []CameraConfig `yaml:"cameras"`
-	Interval          string         `yaml:"interval"`
-	TimelapseInterval string         `yaml:"timelapseInterval"`
-	FrameDuration     float64        `yaml:"frameDuration"`
-	FFmpegTemplate    string         `yaml:"ffmpeg_template"`
-	Logger            *slog.Logger   `yaml:"-"`
+	OutputDir         string          `yaml:"outputDir"`
+	Cameras           []CameraConfig  `yaml:"cameras"`
+	Interval          string          `yaml:"interval"`
+	TimelapseInterval string          `yaml:"timelapseInterval"`
+	FrameDuration     float64         `yaml:"frameDuration"`
+	FFmpegTemplate    string          `yaml:"ffmpeg_template"`
+	Timeout           time.Duration   `yaml:"timeout"`
+	ConnectTimeout    time.Duration   `yaml:"connectTimeout"`
+	Retries           int             `yaml:"retries"`
+	RetryBackoff      time.Duration   `yaml:"retryBackoff"`
+	MaxSize           int64           `yaml:"maxSize"`          // largest accepted snapshot in bytes, default 20 MiB
+	Concurrency       int             `yaml:"concurrency"`      // cameras captured at the same time by -snapshot
+	Layout            string          `yaml:"layout,omitempty"` // snapshot partitions of the camera directories, e.g. YYYY/MM/DD
+	Storage           StorageConfig   `yaml:"storage,omitempty"`
+	HostLimit         HostLimitConfig `yaml:"hostLimit,omitempty"`
+	Push              PushConfig      `yaml:"push,omitempty"`
+	FTP               FTPConfig       `yaml:"ftp,omitempty"`
+	Logger            *slog.Logger    `yaml:"-"`
 }
 
 func newDefaultConfig() Config {
@@ -53,6 +231,12 @@ func newDefaultConfig() Config {
 		TimelapseInterval: defaultTimelapseInterval,
 		FrameDuration:     defaultFrameDuration,
 		FFmpegTemplate:    defaultFFmpegTemplate,
+		Timeout:           defaultTimeout,
+		ConnectTimeout:    defaultConnectTimeout,
+		Retries:           defaultRetries,
+		RetryBackoff:      defaultRetryBackoff,
+		Concurrency:       defaultConcurrency,
+		MaxSize:           defaultMaxSize,
 	}
 }
 
@@ -74,6 +258,19 @@ func NewExampleConfig() (string, error) {
 	return string(data), nil
 }
 
+// MarshalCameras returns the YAML of a cameras section, ready to paste into a
+// configuration file.
+func MarshalCameras(cameras []CameraConfig) (string, error) {
+	data, err := yaml.Marshal(struct {
+		Cameras []CameraConfig `yaml:"cameras"`
+	}{cameras})
+	if err != nil {
+		return "", fmt.Errorf("marshalling cameras: %w", err)
+	}
+
+	return string(data), nil
+}
+
 func LoadConfig(path string, logger *slog.Logger) (*Config, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
@@ -85,18 +282,68 @@ func LoadConfig(path string, logger *slog.Logger) (*Config, error) {
 	// Attach logger to our config, not amazing but sometimes you get to be lazy
 	config.Logger = logger
 
-	if err := yaml.Unmarshal(data, &config); err != nil {
+	var document yaml.Node
+	if err := yaml.Unmarshal(data, &document); err != nil {
 		return nil, fmt.Errorf("error parsing config file: %v", err)
 	}
+	if err := expandNode(&document); err != nil {
+		return nil, fmt.Errorf("error expanding environment variables in config file: %v", err)
+	}
+	if document.Kind != 0 {
+		if err := document.Decode(&config); err != nil {
+			return nil, fmt.Errorf("error parsing config file: %v", err)
+		}
+	}
 
-	applyDefaultsToCameras(&config)
+	if err := resolveSecretFiles(&config); err != nil {
+		return nil, fmt.Errorf("error reading secrets: %v", err)
+	}
+
+	applyDefaultsToCameras(&config, cameraKeys(&document))
 
 	return &config, nil
 }
 
-func applyDefaultsToCameras(config *Config) {
+// cameraKeys returns the keys set in each camera of the document. Zero is a
+// meaningful value for settings such as retries, so whether a camera inherits
+// the global value depends on the key being present rather than on the value.
+func cameraKeys(document *yaml.Node) []map[string]bool {
+	if document.Kind != yaml.DocumentNode || len(document.Content) == 0 {
+		return nil
+	}
+	root := document.Content[0]
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value != "cameras" {
+			continue
+		}
+		var keys []map[string]bool
+		for _, camera := range root.Content[i+1].Content {
+			if camera.Kind == yaml.AliasNode {
+				camera = camera.Alias
+			}
+			set := make(map[string]bool)
+			for j := 0; j+1 < len(camera.Content); j += 2 {
+				set[camera.Content[j].Value] = true
+			}
+			keys = append(keys, set)
+		}
+		return keys
+	}
+	return nil
+}
+
+// applyDefaultsToCameras copies the global settings to the cameras that do not
+// set their own. keys holds the keys set per camera, see cameraKeys: timeout,
+// connectTimeout, retries, retryBackoff and maxSize are inherited only when
+// missing, so that e.g. retries: 0 turns retries off for a single camera.
+// Other settings are inherited when empty or zero.
+func applyDefaultsToCameras(config *Config, keys []map[string]bool) {
 	for i := range config.Cameras {
 		camConfig := &config.Cameras[i]
+		var set map[string]bool
+		if i < len(keys) {
+			set = keys[i]
+		}
 		if camConfig.Interval == "" {
 			camConfig.Interval = config.Interval
 		}
@@ -115,5 +362,23 @@ func applyDefaultsToCameras(config *Config) {
 				"ffmpegTemplate", config.FFmpegTemplate)
 			camConfig.FFmpegTemplate = config.FFmpegTemplate
 		}
+		if !set["timeout"] {
+			camConfig.Timeout = config.Timeout
+		}
+		if !set["connectTimeout"] {
+			camConfig.ConnectTimeout = config.ConnectTimeout
+		}
+		if !set["retries"] {
+			camConfig.Retries = config.Retries
+		}
+		if !set["retryBackoff"] {
+			camConfig.RetryBackoff = config.RetryBackoff
+		}
+		if !set["maxSize"] {
+			camConfig.MaxSize = config.MaxSize
+		}
+		if camConfig.Layout == "" {
+			camConfig.Layout = config.Layout
+		}
 	}
 }
@@ -0,0 +1,155 @@
+package config
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var discardLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("writing config: %v", err)
+	}
+	return path
+}
+
+func TestLoadConfig_secrets(t *testing.T) {
+	t.Setenv("CAM_PASSWORD", `p"a:ss`)
+	t.Setenv("CAM_HOST", "cam.local")
+	t.Setenv("CAM_RETRIES", "5")
+
+	secretDir := t.TempDir()
+	tokenFile := filepath.Join(secretDir, "token")
+	os.WriteFile(tokenFile, []byte("file-token\n"), 0o600)
+
+	path := writeConfig(t, `
+cameras:
+  - name: "Gate"
+    snapshotUrl: "https://${CAM_HOST}/snapshot.jpg"
+    retries: ${CAM_RETRIES}
+    auth:
+      type: basic
+      username: admin
+      password: ${CAM_PASSWORD}
+  - name: "Cloud"
+    snapshotUrl: "https://api.example.com/snapshot"
+    auth:
+      type: bearer
+      tokenFile: `+tokenFile+`
+storage:
+  type: s3
+  s3:
+    bucket: frames
+    secretKeyFile: `+tokenFile+`
+`)
+
+	cfg, err := LoadConfig(path, discardLogger)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	gate := cfg.Cameras[0]
+	if gate.SnapshotURL != "https://cam.local/snapshot.jpg" {
+		t.Errorf("snapshotUrl = %q", gate.SnapshotURL)
+	}
+	if gate.Auth.Password != `p"a:ss` {
+		t.Errorf("password = %q, want %q", gate.Auth.Password, `p"a:ss`)
+	}
+	if gate.Retries != 5 {
+		t.Errorf("retries = %d, want 5", gate.Retries)
+	}
+	if got := cfg.Cameras[1].Auth.Token; got != "file-token" {
+		t.Errorf("token = %q, want %q", got, "file-token")
+	}
+	if got := cfg.Storage.S3.SecretKey; got != "file-token" {
+		t.Errorf("secretKey = %q, want %q", got, "file-token")
+	}
+	if cfg.Cameras[1].Timeout != 30*time.Second {
+		t.Errorf("timeout = %v, want default 30s", cfg.Cameras[1].Timeout)
+	}
+}
+
+func TestLoadConfig_secretErrors(t *testing.T) {
+	tests := []struct {
+		name          string
+		config        string
+		expectedError string
+	}{
+		{
+			name:          "unset variable",
+			config:        "cameras:\n  - name: a\n    auth:\n      password: ${TIMELAPSER_TEST_UNSET}\n",
+			expectedError: "line 4: environment variable not set: TIMELAPSER_TEST_UNSET",
+		},
+		{
+			name:          "missing secret file",
+			config:        "cameras:\n  - name: a\n    auth:\n      passwordFile: /nonexistent/secret\n",
+			expectedError: "camera a: reading passwordFile",
+		},
+		{
+			name:          "password and file",
+			config:        "cameras:\n  - name: a\n    auth:\n      password: x\n      passwordFile: /run/secrets/x\n",
+			expectedError: "password and passwordFile are mutually exclusive",
+		},
+		{
+			name:          "missing storage secret file",
+			config:        "storage:\n  s3:\n    secretKeyFile: /nonexistent/secret\n",
+			expectedError: "storage: reading secretKeyFile",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadConfig(writeConfig(t, tt.config), discardLogger)
+			if err == nil || !strings.Contains(err.Error(), tt.expectedError) {
+				t.Errorf("error = %v, want %v", err, tt.expectedError)
+			}
+		})
+	}
+}
+
+func TestLoadConfig_cameraDefaults(t *testing.T) {
+	path := writeConfig(t, `
+retries: 3
+timeout: 20s
+maxSize: 1024
+cameras:
+  - name: "Inherits"
+  - name: "Overrides"
+    retries: 0
+    timeout: 0s
+    maxSize: 0
+  - name: "Own values"
+    retries: 1
+    timeout: 5s
+`)
+
+	cfg, err := LoadConfig(path, discardLogger)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		retries int
+		timeout time.Duration
+		maxSize int64
+	}{
+		{retries: 3, timeout: 20 * time.Second, maxSize: 1024},
+		{retries: 0, timeout: 0, maxSize: 0},
+		{retries: 1, timeout: 5 * time.Second, maxSize: 1024},
+	}
+	for i, tt := range tests {
+		cam := cfg.Cameras[i]
+		if cam.Retries != tt.retries || cam.Timeout != tt.timeout || cam.MaxSize != tt.maxSize {
+			t.Errorf("%s: retries, timeout, maxSize = %d, %v, %d, want %d, %v, %d",
+				cam.Name, cam.Retries, cam.Timeout, cam.MaxSize, tt.retries, tt.timeout, tt.maxSize)
+		}
+	}
+}
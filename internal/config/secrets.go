@@ -0,0 +1,88 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/stone/timelapser/internal/utils"
+	"gopkg.in/yaml.v3"
+)
+
+// expandNode replaces ${ENV_VAR} references in all scalar values of the
+// parsed document. Expanding after parsing, rather than in the raw file,
+// keeps a secret containing quotes or newlines from changing the YAML
+// structure.
+func expandNode(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		expanded, err := utils.ExpandEnv(node.Value)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", node.Line, err)
+		}
+		if expanded != node.Value {
+			node.Value = expanded
+			// Let unquoted values such as retries: ${RETRIES} resolve to
+			// their real type instead of the string tag of the reference.
+			if node.Style&(yaml.DoubleQuotedStyle|yaml.SingleQuotedStyle) == 0 {
+				node.Tag = ""
+			}
+		}
+		return nil
+	}
+
+	var errs []error
+	for _, child := range node.Content {
+		if err := expandNode(child); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// resolveSecretFiles reads credentials from passwordFile, tokenFile,
+// clientSecretFile and secretKeyFile, e.g. Docker or Kubernetes secrets
+// mounted as files.
+func resolveSecretFiles(config *Config) error {
+	var errs []error
+	s3 := &config.Storage.S3
+	if err := readSecretFile(&s3.SecretKey, s3.SecretKeyFile, "secretKey"); err != nil {
+		errs = append(errs, fmt.Errorf("storage: %w", err))
+	}
+	for i := range config.Cameras {
+		cam := &config.Cameras[i]
+		auth := &cam.Auth
+		for _, secret := range []struct {
+			value *string
+			file  string
+			name  string
+		}{
+			{&auth.Password, auth.PasswordFile, "password"},
+			{&auth.Token, auth.TokenFile, "token"},
+			{&auth.OAuth2.ClientSecret, auth.OAuth2.ClientSecretFile, "clientSecret"},
+			{&cam.Push.Token, cam.Push.TokenFile, "token"},
+			{&cam.FTP.Password, cam.FTP.PasswordFile, "password"},
+		} {
+			if err := readSecretFile(secret.value, secret.file, secret.name); err != nil {
+				errs = append(errs, fmt.Errorf("camera %s: %w", cam.Name, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func readSecretFile(value *string, file, name string) error {
+	if file == "" {
+		return nil
+	}
+	if *value != "" {
+		return fmt.Errorf("%s and %sFile are mutually exclusive", name, name)
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("reading %sFile: %w", name, err)
+	}
+	// Secret files usually end with a newline that is not part of the secret.
+	*value = strings.TrimRight(string(data), "\r\n")
+	return nil
+}
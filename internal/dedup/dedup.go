@@ -0,0 +1,138 @@
+// Package dedup detects snapshots that are identical or nearly identical to
+// the previously stored frame of a camera.
+package dedup
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"image"
+	_ "image/gif"  // register decoder
+	_ "image/jpeg" // register decoder
+	_ "image/png"  // register decoder
+	"io"
+	"math/bits"
+
+	"github.com/stone/timelapser/internal/config"
+)
+
+const (
+	hashWidth  = 9 // dHash compares 8 horizontal neighbours in 9 columns
+	hashHeight = 8
+)
+
+// Fingerprint identifies a frame by content and, when the image could be
+// decoded, by its appearance.
+type Fingerprint struct {
+	Content       [sha256.Size]byte
+	Perceptual    uint64
+	HasPerceptual bool
+}
+
+// NewFingerprint computes the fingerprint of an encoded image. The
+// perceptual hash is only computed when perceptual is set, since it requires
+// decoding the image; formats without a standard library decoder (WebP,
+// AVIF) only get a content hash.
+func NewFingerprint(data []byte, perceptual bool) Fingerprint {
+	// Reading from memory cannot fail.
+	fp, _ := NewFingerprintAt(bytes.NewReader(data), int64(len(data)), perceptual)
+	return fp
+}
+
+// NewFingerprintAt is NewFingerprint for an image of size bytes read from r,
+// e.g. a file, without reading it into memory.
+func NewFingerprintAt(r io.ReaderAt, size int64, perceptual bool) (Fingerprint, error) {
+	var fp Fingerprint
+	h := sha256.New()
+	if _, err := io.Copy(h, io.NewSectionReader(r, 0, size)); err != nil {
+		return Fingerprint{}, err
+	}
+	h.Sum(fp.Content[:0])
+	if !perceptual {
+		return fp, nil
+	}
+	if img, _, err := image.Decode(io.NewSectionReader(r, 0, size)); err == nil {
+		fp.Perceptual = DHash(img)
+		fp.HasPerceptual = true
+	}
+	return fp, nil
+}
+
+// IsDuplicate reports whether fp matches the previous fingerprint according
+// to cfg: either the content is byte-identical or, with perceptual matching
+// enabled, the dHash distance is within the threshold.
+func IsDuplicate(prev, fp Fingerprint, cfg config.DedupConfig) bool {
+	if !cfg.Enabled {
+		return false
+	}
+	if prev.Content == fp.Content {
+		return true
+	}
+	if cfg.Perceptual && prev.HasPerceptual && fp.HasPerceptual {
+		return Distance(prev.Perceptual, fp.Perceptual) <= cfg.Threshold
+	}
+	return false
+}
+
+// Distance returns the number of differing bits between two perceptual hashes.
+func Distance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// DHash computes the difference hash of img: the image is reduced to 9x8
+// average luminance cells and each bit records whether a cell is brighter
+// than its right neighbour. Small changes such as JPEG noise or a changed
+// timestamp overlay flip only a few bits.
+func DHash(img image.Image) uint64 {
+	var sums [hashHeight][hashWidth]uint64
+	var counts [hashHeight][hashWidth]uint64
+
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w == 0 || h == 0 {
+		return 0
+	}
+
+	add := func(x, y int, lum uint64) {
+		cx := x * hashWidth / w
+		cy := y * hashHeight / h
+		sums[cy][cx] += lum
+		counts[cy][cx]++
+	}
+
+	// Camera JPEGs decode to YCbCr; reading the Y plane directly avoids a
+	// colour conversion per pixel.
+	if ycc, ok := img.(*image.YCbCr); ok {
+		for y := 0; y < h; y++ {
+			row := ycc.Y[ycc.YOffset(b.Min.X, b.Min.Y+y):]
+			for x := 0; x < w; x++ {
+				add(x, y, uint64(row[x]))
+			}
+		}
+	} else {
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				r, g, bl, _ := img.At(b.Min.X+x, b.Min.Y+y).RGBA()
+				// ITU-R 601 luma on 16-bit channels, scaled to 8 bits
+				add(x, y, uint64(299*r+587*g+114*bl)/1000>>8)
+			}
+		}
+	}
+
+	var hash uint64
+	for y := 0; y < hashHeight; y++ {
+		for x := 0; x < hashWidth-1; x++ {
+			hash <<= 1
+			if avg(sums[y][x], counts[y][x]) > avg(sums[y][x+1], counts[y][x+1]) {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+func avg(sum, count uint64) uint64 {
+	if count == 0 {
+		return 0
+	}
+	return sum / count
+}
@@ -0,0 +1,91 @@
+package dedup
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+
+	"github.com/stone/timelapser/internal/config"
+)
+
+// scene renders a horizontal gradient; shift moves a bright block across
+// the image to simulate a real change, noise sprinkles small pixel changes.
+func scene(shift int, noise bool) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, 160, 120))
+	for y := 0; y < 120; y++ {
+		for x := 0; x < 160; x++ {
+			v := uint8(x * 255 / 160)
+			if x >= shift && x < shift+40 && y >= 40 && y < 80 {
+				v = 255 - v
+			}
+			if noise && (x*7+y*13)%29 == 0 {
+				v ^= 0x08
+			}
+			img.Set(x, y, color.RGBA{v, v, v, 255})
+		}
+	}
+	var buf bytes.Buffer
+	jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90})
+	return buf.Bytes()
+}
+
+func TestIsDuplicate(t *testing.T) {
+	base := scene(20, false)
+
+	tests := []struct {
+		name     string
+		next     []byte
+		cfg      config.DedupConfig
+		expected bool
+	}{
+		{
+			name:     "disabled",
+			next:     base,
+			cfg:      config.DedupConfig{},
+			expected: false,
+		},
+		{
+			name:     "identical content",
+			next:     bytes.Clone(base),
+			cfg:      config.DedupConfig{Enabled: true},
+			expected: true,
+		},
+		{
+			name:     "noise without perceptual matching",
+			next:     scene(20, true),
+			cfg:      config.DedupConfig{Enabled: true},
+			expected: false,
+		},
+		{
+			name:     "noise with perceptual matching",
+			next:     scene(20, true),
+			cfg:      config.DedupConfig{Enabled: true, Perceptual: true, Threshold: 4},
+			expected: true,
+		},
+		{
+			name:     "moved object with perceptual matching",
+			next:     scene(100, false),
+			cfg:      config.DedupConfig{Enabled: true, Perceptual: true, Threshold: 4},
+			expected: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prev := NewFingerprint(base, tt.cfg.Perceptual)
+			next := NewFingerprint(tt.next, tt.cfg.Perceptual)
+			if got := IsDuplicate(prev, next, tt.cfg); got != tt.expected {
+				t.Errorf("IsDuplicate() = %v, want %v (distance %d)", got, tt.expected, Distance(prev.Perceptual, next.Perceptual))
+			}
+		})
+	}
+}
+
+func TestNewFingerprint_undecodable(t *testing.T) {
+	fp := NewFingerprint([]byte("RIFF\x19\x00\x00\x00WEBPVP8L"), true)
+	if fp.HasPerceptual {
+		t.Error("expected no perceptual hash for an undecodable image")
+	}
+}
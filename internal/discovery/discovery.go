@@ -0,0 +1,118 @@
+// Package discovery finds cameras on the local network and turns them into
+// camera configurations.
+package discovery
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/stone/timelapser/internal/config"
+)
+
+// Device is a camera found on the network.
+type Device struct {
+	Address     string // IP address
+	Name        string // name reported by the device, may be empty
+	Model       string // hardware reported by the device, may be empty
+	ONVIFURL    string // device service URL, set for ONVIF devices
+	SnapshotURL string // set for devices found by scanning
+	AuthScheme  string // "basic" or "digest" when the snapshot URL asks for credentials
+}
+
+// passwordPlaceholder is written instead of a password so the generated
+// configuration does not work until a real secret is provided.
+const passwordPlaceholder = "${CAMERA_PASSWORD}"
+
+var nonNameChars = regexp.MustCompile(`[^A-Za-z0-9]+`)
+
+// CameraConfigs returns a camera configuration per device, sorted by address.
+// ONVIF devices use the onvif type, scanned devices the http type with the
+// authentication scheme their snapshot URL asked for, if any.
+func CameraConfigs(devices []Device) []config.CameraConfig {
+	devices = append([]Device(nil), devices...)
+	sort.Slice(devices, func(i, j int) bool {
+		a, b := net.ParseIP(devices[i].Address), net.ParseIP(devices[j].Address)
+		if a != nil && b != nil {
+			return string(a.To16()) < string(b.To16())
+		}
+		return devices[i].Address < devices[j].Address
+	})
+
+	cameras := make([]config.CameraConfig, 0, len(devices))
+	for _, d := range devices {
+		cam := config.CameraConfig{Name: cameraName(d)}
+		credentials := config.AuthConfig{Username: "admin", Password: passwordPlaceholder}
+		if d.ONVIFURL != "" {
+			cam.Type = "onvif"
+			cam.ONVIF.Host = onvifHost(d.ONVIFURL)
+			cam.Auth = credentials
+		} else {
+			cam.SnapshotURL = d.SnapshotURL
+			if d.AuthScheme != "" {
+				cam.Auth = credentials
+				cam.Auth.Type = d.AuthScheme
+			}
+		}
+		cameras = append(cameras, cam)
+	}
+	return cameras
+}
+
+func cameraName(d Device) string {
+	name := strings.Trim(nonNameChars.ReplaceAllString(d.Name, " "), " ")
+	if name == "" {
+		name = strings.Trim(nonNameChars.ReplaceAllString(d.Model, " "), " ")
+	}
+	suffix := strings.ReplaceAll(d.Address, ":", "-")
+	if name == "" {
+		return "camera " + suffix
+	}
+	return name + " " + suffix
+}
+
+// onvifHost shortens the device service URL to host[:port] when it uses the
+// standard path, which is what onvif.host expects.
+func onvifHost(deviceURL string) string {
+	u, err := url.Parse(deviceURL)
+	if err != nil || u.Scheme != "http" || u.Path != "/onvif/device_service" || u.RawQuery != "" {
+		return deviceURL
+	}
+	return u.Host
+}
+
+// hostsInCIDR returns the host addresses of an IPv4 network, without network
+// and broadcast address. Networks larger than /22 are refused.
+func hostsInCIDR(cidr string) ([]string, error) {
+	ip, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, err
+	}
+	if ip.To4() == nil {
+		return nil, fmt.Errorf("only IPv4 networks can be scanned: %s", cidr)
+	}
+	ones, bits := network.Mask.Size()
+	if bits-ones > 10 {
+		return nil, fmt.Errorf("network %s is too large to scan, use /22 or smaller", cidr)
+	}
+
+	start := ipToUint(network.IP.To4())
+	size := uint32(1) << (bits - ones)
+	var hosts []string
+	for i := uint32(0); i < size; i++ {
+		// Skip network and broadcast addresses unless the network is tiny.
+		if size > 2 && (i == 0 || i == size-1) {
+			continue
+		}
+		n := start + i
+		hosts = append(hosts, net.IPv4(byte(n>>24), byte(n>>16), byte(n>>8), byte(n)).String())
+	}
+	return hosts, nil
+}
+
+func ipToUint(ip net.IP) uint32 {
+	return uint32(ip[0])<<24 | uint32(ip[1])<<16 | uint32(ip[2])<<8 | uint32(ip[3])
+}
@@ -0,0 +1,79 @@
+package discovery
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/stone/timelapser/internal/config"
+)
+
+func TestCameraConfigs(t *testing.T) {
+	cameras := CameraConfigs([]Device{
+		{Address: "192.168.1.20", SnapshotURL: "http://192.168.1.20/cgi-bin/snapshot.cgi", AuthScheme: "digest"},
+		{Address: "192.168.1.21", SnapshotURL: "http://192.168.1.21/snapshot.jpg"},
+		{Address: "192.168.1.3", Name: "Front Door", ONVIFURL: "http://192.168.1.3/onvif/device_service"},
+		{Address: "192.168.1.10", Model: "IPC-HDW2431", ONVIFURL: "http://192.168.1.10:8000/onvif/device_service"},
+		{Address: "192.168.1.11", ONVIFURL: "http://192.168.1.11/onvif/services"},
+	})
+
+	var names, hosts, auth []string
+	for _, cam := range cameras {
+		names = append(names, cam.Name)
+		hosts = append(hosts, cam.ONVIF.Host+cam.SnapshotURL)
+		auth = append(auth, cam.Auth.Type+":"+cam.Auth.Username)
+	}
+	expectedNames := []string{"Front Door 192.168.1.3", "IPC HDW2431 192.168.1.10", "camera 192.168.1.11", "camera 192.168.1.20", "camera 192.168.1.21"}
+	if !reflect.DeepEqual(names, expectedNames) {
+		t.Errorf("names = %q, want %q", names, expectedNames)
+	}
+	expectedHosts := []string{"192.168.1.3", "192.168.1.10:8000", "http://192.168.1.11/onvif/services", "http://192.168.1.20/cgi-bin/snapshot.cgi", "http://192.168.1.21/snapshot.jpg"}
+	if !reflect.DeepEqual(hosts, expectedHosts) {
+		t.Errorf("hosts = %q, want %q", hosts, expectedHosts)
+	}
+	// ONVIF cameras pick their scheme at runtime, open snapshot URLs need none.
+	expectedAuth := []string{":admin", ":admin", ":admin", "digest:admin", ":"}
+	if !reflect.DeepEqual(auth, expectedAuth) {
+		t.Errorf("auth = %q, want %q", auth, expectedAuth)
+	}
+
+	out, err := config.MarshalCameras(cameras[:1])
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, want := range []string{"cameras:\n", "type: onvif\n", "host: 192.168.1.3\n", "password: ${CAMERA_PASSWORD}\n"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("yaml does not contain %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestHostsInCIDR(t *testing.T) {
+	tests := []struct {
+		cidr          string
+		expectedCount int
+		expectedFirst string
+		expectedError string
+	}{
+		{cidr: "192.168.1.0/24", expectedCount: 254, expectedFirst: "192.168.1.1"},
+		{cidr: "10.0.0.5/32", expectedCount: 1, expectedFirst: "10.0.0.5"},
+		{cidr: "10.0.0.0/16", expectedError: "too large"},
+		{cidr: "fd00::/120", expectedError: "only IPv4"},
+		{cidr: "192.168.1.0", expectedError: "invalid CIDR"},
+	}
+	for _, tt := range tests {
+		hosts, err := hostsInCIDR(tt.cidr)
+		if tt.expectedError != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.expectedError) {
+				t.Errorf("%s: error = %v, want %v", tt.cidr, err, tt.expectedError)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.cidr, err)
+		}
+		if len(hosts) != tt.expectedCount || hosts[0] != tt.expectedFirst {
+			t.Errorf("%s: %d hosts starting at %s, want %d starting at %s", tt.cidr, len(hosts), hosts[0], tt.expectedCount, tt.expectedFirst)
+		}
+	}
+}
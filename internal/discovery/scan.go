@@ -0,0 +1,141 @@
+package discovery
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stone/timelapser/internal/imageformat"
+)
+
+// snapshotPaths are the snapshot URLs of common camera brands.
+var snapshotPaths = []string{
+	"/ISAPI/Streaming/channels/101/picture",   // Hikvision
+	"/cgi-bin/snapshot.cgi",                   // Dahua, Amcrest
+	"/cgi-bin/api.cgi?cmd=Snap&channel=0",     // Reolink
+	"/axis-cgi/jpg/image.cgi",                 // Axis
+	"/cgi-bin/CGIProxy.fcgi?cmd=snapPicture2", // Foscam
+	"/tmpfs/auto.jpg",                         // Instar
+	"/snapshot.jpg",
+	"/snap.jpg",
+	"/image.jpg",
+}
+
+const (
+	scanWorkers     = 64
+	scanDialTimeout = 500 * time.Millisecond
+	scanTimeout     = 3 * time.Second
+)
+
+// Scan looks for snapshot URLs on port 80 of every host in cidr.
+func Scan(ctx context.Context, cidr string) ([]Device, error) {
+	hosts, err := hostsInCIDR(cidr)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := &net.Dialer{Timeout: scanDialTimeout}
+	client := &http.Client{
+		Timeout:   scanTimeout,
+		Transport: &http.Transport{DialContext: dialer.DialContext},
+		// A redirect usually leads to a login page, not an image.
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+
+	var (
+		mu      sync.Mutex
+		devices []Device
+		wg      sync.WaitGroup
+	)
+	queue := make(chan string)
+	for range scanWorkers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for host := range queue {
+				// Skip hosts without a web server before trying every path.
+				conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(host, "80"))
+				if err != nil {
+					continue
+				}
+				conn.Close()
+				if snapshotURL, scheme := scanHost(ctx, client, "http://"+host); snapshotURL != "" {
+					mu.Lock()
+					devices = append(devices, Device{Address: host, SnapshotURL: snapshotURL, AuthScheme: scheme})
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for _, host := range hosts {
+		select {
+		case queue <- host:
+		case <-ctx.Done():
+		}
+	}
+	close(queue)
+	wg.Wait()
+
+	return devices, ctx.Err()
+}
+
+// scanHost returns the first snapshot URL of baseURL that answers with an
+// image. A path answering 401 counts too, unless the server asks for
+// credentials on any path; its authentication scheme is returned with it.
+func scanHost(ctx context.Context, client *http.Client, baseURL string) (snapshotURL, scheme string) {
+	authEverywhere := status(ctx, client, baseURL+"/timelapser-discovery-probe") == http.StatusUnauthorized
+
+	var protected, protectedScheme string
+	for _, path := range snapshotPaths {
+		resp, err := get(ctx, client, baseURL+path)
+		if err != nil {
+			continue
+		}
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		resp.Body.Close()
+
+		switch {
+		case resp.StatusCode == http.StatusOK:
+			if _, err := imageformat.Detect(data, resp.Header.Get("Content-Type")); err == nil {
+				return baseURL + path, ""
+			}
+		case resp.StatusCode == http.StatusUnauthorized && !authEverywhere && protected == "":
+			protected, protectedScheme = baseURL+path, authScheme(resp.Header)
+		}
+	}
+	return protected, protectedScheme
+}
+
+// authScheme returns "digest" when a 401 response offers digest
+// authentication and "basic" otherwise, which is also what servers sending no
+// WWW-Authenticate header usually accept.
+func authScheme(header http.Header) string {
+	for _, challenge := range header.Values("WWW-Authenticate") {
+		scheme, _, _ := strings.Cut(strings.TrimSpace(challenge), " ")
+		if strings.EqualFold(scheme, "digest") {
+			return "digest"
+		}
+	}
+	return "basic"
+}
+
+func get(ctx context.Context, client *http.Client, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return client.Do(req)
+}
+
+func status(ctx context.Context, client *http.Client, url string) int {
+	resp, err := get(ctx, client, url)
+	if err != nil {
+		return 0
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
@@ -0,0 +1,86 @@
+package discovery
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestScanHost(t *testing.T) {
+	jpeg := []byte("\xff\xd8\xff\xe0\x00\x10JFIF")
+
+	tests := []struct {
+		name           string
+		handler        http.HandlerFunc
+		expected       string
+		expectedScheme string
+	}{
+		{
+			name: "image",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/axis-cgi/jpg/image.cgi" {
+					w.Write(jpeg)
+					return
+				}
+				http.NotFound(w, r)
+			},
+			expected: "/axis-cgi/jpg/image.cgi",
+		},
+		{
+			name: "protected path",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/cgi-bin/snapshot.cgi" {
+					w.Header().Set("WWW-Authenticate", `Basic realm="camera"`)
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				http.NotFound(w, r)
+			},
+			expected:       "/cgi-bin/snapshot.cgi",
+			expectedScheme: "basic",
+		},
+		{
+			name: "digest protected path",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/ISAPI/Streaming/channels/101/picture" {
+					w.Header().Add("WWW-Authenticate", `Basic realm="camera"`)
+					w.Header().Add("WWW-Authenticate", `Digest realm="camera", qop="auth", nonce="abc"`)
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				http.NotFound(w, r)
+			},
+			expected:       "/ISAPI/Streaming/channels/101/picture",
+			expectedScheme: "digest",
+		},
+		{
+			name: "authentication on every path",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusUnauthorized)
+			},
+		},
+		{
+			name: "html instead of image",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("<html>router login</html>"))
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(tt.handler)
+			defer server.Close()
+
+			got, scheme := scanHost(context.Background(), server.Client(), server.URL)
+			expected := ""
+			if tt.expected != "" {
+				expected = server.URL + tt.expected
+			}
+			if got != expected || scheme != tt.expectedScheme {
+				t.Errorf("scanHost() = %q, %q, want %q, %q", got, scheme, expected, tt.expectedScheme)
+			}
+		})
+	}
+}
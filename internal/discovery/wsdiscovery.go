@@ -0,0 +1,117 @@
+package discovery
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/xml"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// wsDiscoveryAddr is the WS-Discovery multicast group.
+var wsDiscoveryAddr = &net.UDPAddr{IP: net.IPv4(239, 255, 255, 250), Port: 3702}
+
+// Probe sends a WS-Discovery probe for ONVIF video devices and collects the
+// answers until timeout.
+func Probe(ctx context.Context, timeout time.Duration) ([]Device, error) {
+	return probe(ctx, wsDiscoveryAddr, timeout)
+}
+
+func probe(ctx context.Context, target *net.UDPAddr, timeout time.Duration) ([]Device, error) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		return nil, fmt.Errorf("opening udp socket: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.WriteToUDP(probeMessage(), target); err != nil {
+		return nil, fmt.Errorf("sending probe: %w", err)
+	}
+
+	deadline := time.Now().Add(timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetReadDeadline(deadline)
+	stop := context.AfterFunc(ctx, func() { conn.SetReadDeadline(time.Now()) })
+	defer stop()
+
+	seen := make(map[string]bool)
+	var devices []Device
+	buf := make([]byte, 64<<10)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			// The read deadline ends the collection.
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				return devices, nil
+			}
+			return devices, err
+		}
+		for _, d := range parseProbeMatches(buf[:n], from.IP.String()) {
+			if !seen[d.ONVIFURL] {
+				seen[d.ONVIFURL] = true
+				devices = append(devices, d)
+			}
+		}
+	}
+}
+
+func probeMessage() []byte {
+	id := make([]byte, 16)
+	rand.Read(id)
+	id[6] = id[6]&0x0f | 0x40 // UUID version 4
+	id[8] = id[8]&0x3f | 0x80
+	uuid := fmt.Sprintf("%x-%x-%x-%x-%x", id[0:4], id[4:6], id[6:8], id[8:10], id[10:])
+
+	return []byte(`<?xml version="1.0" encoding="UTF-8"?>` +
+		`<e:Envelope xmlns:e="http://www.w3.org/2003/05/soap-envelope" xmlns:w="http://schemas.xmlsoap.org/ws/2004/08/addressing" ` +
+		`xmlns:d="http://schemas.xmlsoap.org/ws/2005/04/discovery" xmlns:dn="http://www.onvif.org/ver10/network/wsdl">` +
+		`<e:Header><w:MessageID>uuid:` + uuid + `</w:MessageID>` +
+		`<w:To e:mustUnderstand="true">urn:schemas-xmlsoap-org:ws:2005:04:discovery</w:To>` +
+		`<w:Action e:mustUnderstand="true">http://schemas.xmlsoap.org/ws/2005/04/discovery/Probe</w:Action></e:Header>` +
+		`<e:Body><d:Probe><d:Types>dn:NetworkVideoTransmitter</d:Types></d:Probe></e:Body></e:Envelope>`)
+}
+
+// parseProbeMatches returns a device per probe match. Devices often list
+// several service addresses; the one on the address that answered is
+// preferred.
+func parseProbeMatches(data []byte, from string) []Device {
+	var resp struct {
+		Matches []struct {
+			XAddrs string `xml:"XAddrs"`
+			Scopes string `xml:"Scopes"`
+		} `xml:"Body>ProbeMatches>ProbeMatch"`
+	}
+	if err := xml.Unmarshal(data, &resp); err != nil {
+		return nil
+	}
+
+	var devices []Device
+	for _, match := range resp.Matches {
+		xaddrs := strings.Fields(match.XAddrs)
+		if len(xaddrs) == 0 {
+			continue
+		}
+		d := Device{Address: from, ONVIFURL: xaddrs[0]}
+		for _, xaddr := range xaddrs {
+			if u, err := url.Parse(xaddr); err == nil && u.Hostname() == from {
+				d.ONVIFURL = xaddr
+				break
+			}
+		}
+		for _, scope := range strings.Fields(match.Scopes) {
+			if v, ok := strings.CutPrefix(scope, "onvif://www.onvif.org/name/"); ok {
+				d.Name, _ = url.PathUnescape(v)
+			}
+			if v, ok := strings.CutPrefix(scope, "onvif://www.onvif.org/hardware/"); ok {
+				d.Model, _ = url.PathUnescape(v)
+			}
+		}
+		devices = append(devices, d)
+	}
+	return devices
+}
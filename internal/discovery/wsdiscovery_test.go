@@ -0,0 +1,49 @@
+package discovery
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+const probeMatches = `<?xml version="1.0" encoding="UTF-8"?>
+<SOAP-ENV:Envelope xmlns:SOAP-ENV="http://www.w3.org/2003/05/soap-envelope" xmlns:d="http://schemas.xmlsoap.org/ws/2005/04/discovery">
+<SOAP-ENV:Body><d:ProbeMatches><d:ProbeMatch>
+<d:Scopes>onvif://www.onvif.org/type/video_encoder onvif://www.onvif.org/name/Front%20Door onvif://www.onvif.org/hardware/DS-2CD2143</d:Scopes>
+<d:XAddrs>http://169.254.10.10/onvif/device_service http://127.0.0.1/onvif/device_service</d:XAddrs>
+</d:ProbeMatch></d:ProbeMatches></SOAP-ENV:Body></SOAP-ENV:Envelope>`
+
+func TestProbe(t *testing.T) {
+	responder, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer responder.Close()
+	go func() {
+		buf := make([]byte, 8192)
+		n, from, err := responder.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		if !strings.Contains(string(buf[:n]), "dn:NetworkVideoTransmitter") {
+			t.Errorf("unexpected probe: %s", buf[:n])
+		}
+		// Devices often answer twice, e.g. on several interfaces.
+		responder.WriteToUDP([]byte(probeMatches), from)
+		responder.WriteToUDP([]byte(probeMatches), from)
+	}()
+
+	devices, err := probe(context.Background(), responder.LocalAddr().(*net.UDPAddr), 200*time.Millisecond)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(devices) != 1 {
+		t.Fatalf("devices = %+v, want 1", devices)
+	}
+	expected := Device{Address: "127.0.0.1", Name: "Front Door", Model: "DS-2CD2143", ONVIFURL: "http://127.0.0.1/onvif/device_service"}
+	if devices[0] != expected {
+		t.Errorf("device = %+v, want %+v", devices[0], expected)
+	}
+}
@@ -0,0 +1,109 @@
+// Package hostlimit limits concurrency and rate of requests per camera host.
+package hostlimit
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/stone/timelapser/internal/config"
+)
+
+// Limits hands out a limiter per host, all with the same configuration.
+type Limits struct {
+	cfg config.HostLimitConfig
+
+	mu    sync.Mutex
+	hosts map[string]*limiter
+}
+
+func New(cfg config.HostLimitConfig) *Limits {
+	return &Limits{cfg: cfg, hosts: make(map[string]*limiter)}
+}
+
+// Acquire blocks until a request to host may start and returns the function
+// that ends it. An empty host, e.g. a local file source, is never limited.
+func (l *Limits) Acquire(ctx context.Context, host string) (release func(), err error) {
+	if l == nil || host == "" || (l.cfg.Concurrency <= 0 && l.cfg.Rate <= 0) {
+		return func() {}, nil
+	}
+
+	l.mu.Lock()
+	lim, ok := l.hosts[host]
+	if !ok {
+		lim = newLimiter(l.cfg)
+		l.hosts[host] = lim
+	}
+	l.mu.Unlock()
+
+	return lim.acquire(ctx)
+}
+
+type limiter struct {
+	sem      chan struct{} // nil if concurrency is unlimited
+	interval time.Duration // minimum time between request starts
+
+	mu   sync.Mutex
+	next time.Time // earliest start of the next request
+}
+
+func newLimiter(cfg config.HostLimitConfig) *limiter {
+	lim := &limiter{}
+	if cfg.Concurrency > 0 {
+		lim.sem = make(chan struct{}, cfg.Concurrency)
+	}
+	if cfg.Rate > 0 {
+		lim.interval = time.Duration(float64(time.Second) / cfg.Rate)
+	}
+	return lim
+}
+
+func (l *limiter) acquire(ctx context.Context) (func(), error) {
+	if l.sem != nil {
+		select {
+		case l.sem <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	release := func() {
+		if l.sem != nil {
+			<-l.sem
+		}
+	}
+
+	if err := l.wait(ctx); err != nil {
+		release()
+		return nil, err
+	}
+	return release, nil
+}
+
+// wait reserves the next start slot and sleeps until it is reached.
+func (l *limiter) wait(ctx context.Context) error {
+	if l.interval <= 0 {
+		return nil
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	start := l.next
+	if start.Before(now) {
+		start = now
+	}
+	l.next = start.Add(l.interval)
+	l.mu.Unlock()
+
+	delay := time.Until(start)
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
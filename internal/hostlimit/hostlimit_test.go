@@ -0,0 +1,89 @@
+package hostlimit
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stone/timelapser/internal/config"
+)
+
+func TestLimits_concurrency(t *testing.T) {
+	limits := New(config.HostLimitConfig{Concurrency: 2})
+
+	var running, maxRunning atomic.Int32
+	var wg sync.WaitGroup
+	for range 6 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			release, err := limits.Acquire(context.Background(), "nvr.local")
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+				return
+			}
+			n := running.Add(1)
+			for {
+				m := maxRunning.Load()
+				if n <= m || maxRunning.CompareAndSwap(m, n) {
+					break
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+			running.Add(-1)
+			release()
+		}()
+	}
+	wg.Wait()
+
+	if got := maxRunning.Load(); got != 2 {
+		t.Errorf("max concurrent = %d, want 2", got)
+	}
+}
+
+func TestLimits_rate(t *testing.T) {
+	limits := New(config.HostLimitConfig{Rate: 20}) // one per 50ms
+
+	start := time.Now()
+	for range 3 {
+		release, err := limits.Acquire(context.Background(), "nvr.local")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		release()
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("3 requests took %v, want at least 100ms", elapsed)
+	}
+
+	// Other hosts have their own budget.
+	start = time.Now()
+	release, _ := limits.Acquire(context.Background(), "other.local")
+	release()
+	if elapsed := time.Since(start); elapsed > 20*time.Millisecond {
+		t.Errorf("other host waited %v", elapsed)
+	}
+}
+
+func TestLimits_cancel(t *testing.T) {
+	limits := New(config.HostLimitConfig{Concurrency: 1})
+	release, err := limits.Acquire(context.Background(), "nvr.local")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := limits.Acquire(ctx, "nvr.local"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("error = %v, want deadline exceeded", err)
+	}
+
+	// Local sources are never limited.
+	if _, err := limits.Acquire(ctx, ""); err != nil {
+		t.Errorf("empty host: unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,103 @@
+// Package imageformat identifies snapshot image formats from their content.
+package imageformat
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+// Format describes an image format accepted as a snapshot.
+type Format struct {
+	Name      string // short name, e.g. "jpeg"
+	Extension string // file extension including the dot
+	MIMEType  string
+}
+
+var (
+	JPEG = Format{Name: "jpeg", Extension: ".jpg", MIMEType: "image/jpeg"}
+	PNG  = Format{Name: "png", Extension: ".png", MIMEType: "image/png"}
+	GIF  = Format{Name: "gif", Extension: ".gif", MIMEType: "image/gif"}
+	WebP = Format{Name: "webp", Extension: ".webp", MIMEType: "image/webp"}
+	AVIF = Format{Name: "avif", Extension: ".avif", MIMEType: "image/avif"}
+)
+
+// extensions lists the file extensions of images: those of stored snapshots
+// plus .jpeg, which cameras use for files they drop or upload over FTP. In
+// the camera directory only files named by timelapser count as snapshots,
+// see layout.FrameTime.
+var extensions = []string{".jpg", ".jpeg", ".png", ".gif", ".webp", ".avif"}
+
+// Detect identifies the image format from the magic bytes of data. The
+// Content-Type reported by the camera is only used to explain why a payload
+// was rejected, since cameras frequently send wrong or generic types.
+func Detect(data []byte, contentType string) (Format, error) {
+	switch {
+	case len(data) == 0:
+		return Format{}, fmt.Errorf("empty response, not an image")
+	case bytes.HasPrefix(data, []byte("\xff\xd8\xff")):
+		return JPEG, nil
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		return PNG, nil
+	case bytes.HasPrefix(data, []byte("GIF87a")), bytes.HasPrefix(data, []byte("GIF89a")):
+		return GIF, nil
+	case len(data) >= 12 && string(data[0:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		return WebP, nil
+	case isAVIF(data):
+		return AVIF, nil
+	}
+
+	detected := http.DetectContentType(data)
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil && mediaType != "" {
+		return Format{}, fmt.Errorf("not a supported image (content type %s, detected %s)", mediaType, detected)
+	}
+	return Format{}, fmt.Errorf("not a supported image (detected %s)", detected)
+}
+
+// sniffLen is how much of an image Detect needs, as http.DetectContentType.
+const sniffLen = 512
+
+// DetectAt is Detect for an image of size bytes read from r, e.g. a file.
+func DetectAt(r io.ReaderAt, size int64, contentType string) (Format, error) {
+	header := make([]byte, min(size, sniffLen))
+	if _, err := io.ReadFull(io.NewSectionReader(r, 0, size), header); err != nil {
+		return Format{}, fmt.Errorf("reading image: %w", err)
+	}
+	return Detect(header, contentType)
+}
+
+// isAVIF checks for an ISO BMFF ftyp box with an AVIF major or compatible brand.
+func isAVIF(data []byte) bool {
+	if len(data) < 16 || string(data[4:8]) != "ftyp" {
+		return false
+	}
+	size := int(data[0])<<24 | int(data[1])<<16 | int(data[2])<<8 | int(data[3])
+	if size < 16 || size > len(data) {
+		size = min(len(data), 64)
+	}
+	// major brand at 8, minor version at 12, compatible brands from 16
+	for i := 8; i+4 <= size; i += 4 {
+		if i == 12 {
+			continue
+		}
+		if brand := string(data[i : i+4]); brand == "avif" || brand == "avis" {
+			return true
+		}
+	}
+	return false
+}
+
+// IsImage reports whether name has the extension of a supported image format.
+func IsImage(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	for _, e := range extensions {
+		if ext == e {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,70 @@
+package imageformat
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		name          string
+		data          string
+		contentType   string
+		expected      Format
+		expectedError string
+	}{
+		{name: "jpeg", data: "\xff\xd8\xff\xe0\x00\x10JFIF", expected: JPEG},
+		{name: "jpeg with wrong content type", data: "\xff\xd8\xff\xdb", contentType: "image/png", expected: JPEG},
+		{name: "png", data: "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR", expected: PNG},
+		{name: "gif", data: "GIF89a\x01\x00\x01\x00", expected: GIF},
+		{name: "webp", data: "RIFF\x24\x00\x00\x00WEBPVP8 ", expected: WebP},
+		{name: "avif major brand", data: "\x00\x00\x00\x1cftypavif\x00\x00\x00\x00avifmif1miaf", expected: AVIF},
+		{name: "avif compatible brand", data: "\x00\x00\x00\x1cftypmif1\x00\x00\x00\x00mif1avifmiaf", expected: AVIF},
+		{name: "mp4 is not avif", data: "\x00\x00\x00\x18ftypisom\x00\x00\x02\x00isomiso2", expectedError: "not a supported image"},
+		{
+			name:          "html error page",
+			data:          "<!DOCTYPE html><html><body>401 Unauthorized</body></html>",
+			contentType:   "text/html; charset=utf-8",
+			expectedError: "content type text/html, detected text/html",
+		},
+		{name: "empty body", data: "", expectedError: "empty response"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			format, err := Detect([]byte(tt.data), tt.contentType)
+
+			if tt.expectedError != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.expectedError) {
+					t.Errorf("error = %v, want %v", err, tt.expectedError)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if format != tt.expected {
+				t.Errorf("Detect() = %v, want %v", format, tt.expected)
+			}
+		})
+	}
+}
+
+func TestIsImage(t *testing.T) {
+	tests := map[string]bool{
+		"1700000000000000000.jpg":     true,
+		"1700000000000000000.JPEG":    true,
+		"1700000000000000000.webp":    true,
+		"1700000000000000000.avif":    true,
+		"1700000000000000000.gif":     true,
+		"1700000000000000000.png":     true,
+		"1700000000000000000.jpg.tmp": false,
+		"camera-20240101.mp4":         false,
+		"notes.txt":                   false,
+	}
+	for name, expected := range tests {
+		if got := IsImage(name); got != expected {
+			t.Errorf("IsImage(%q) = %v, want %v", name, got, expected)
+		}
+	}
+}
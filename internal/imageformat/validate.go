@@ -0,0 +1,179 @@
+package imageformat
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+)
+
+const (
+	// trailerSize is how much of the end of an image is read to find the
+	// end-of-image marker, allowing for padding after it.
+	trailerSize = 1 << 10
+	// avifHeaderSize is how much of an AVIF image is searched for its size,
+	// which sits in the meta box at the start of the file.
+	avifHeaderSize = 64 << 10
+)
+
+// Decode checks that data is a well-formed image of format f and returns its
+// dimensions. By default only the header is decoded and the end-of-image
+// marker is checked, which catches zero-byte and most truncated frames. With
+// full set, JPEG, PNG and GIF images are decoded completely. WebP and AVIF
+// have no decoder in the standard library and are checked structurally.
+func Decode(data []byte, f Format, full bool) (image.Config, error) {
+	return DecodeAt(bytes.NewReader(data), int64(len(data)), f, full)
+}
+
+// DecodeAt is Decode for an image of size bytes read from r, e.g. a file.
+// Only the parts that are checked are read into memory.
+func DecodeAt(r io.ReaderAt, size int64, f Format, full bool) (image.Config, error) {
+	switch f {
+	case JPEG:
+		return decodeStd(r, size, full, jpeg.DecodeConfig, jpeg.Decode, checkJPEGTrailer)
+	case PNG:
+		return decodeStd(r, size, full, png.DecodeConfig, png.Decode, checkPNGTrailer)
+	case GIF:
+		return decodeStd(r, size, full, gif.DecodeConfig, gif.Decode, checkGIFTrailer)
+	case WebP:
+		head, err := readAt(r, 0, min(size, webpHeaderSize))
+		if err != nil {
+			return image.Config{}, err
+		}
+		return decodeWebP(head, size)
+	case AVIF:
+		head, err := readAt(r, 0, min(size, avifHeaderSize))
+		if err != nil {
+			return image.Config{}, err
+		}
+		return decodeAVIF(head)
+	}
+	return image.Config{}, fmt.Errorf("no decoder for %s", f.Name)
+}
+
+// readAt reads n bytes at off.
+func readAt(r io.ReaderAt, off, n int64) ([]byte, error) {
+	data := make([]byte, n)
+	if _, err := io.ReadFull(io.NewSectionReader(r, off, n), data); err != nil {
+		return nil, fmt.Errorf("reading image: %w", err)
+	}
+	return data, nil
+}
+
+func decodeStd(
+	r io.ReaderAt,
+	size int64,
+	full bool,
+	decodeConfig func(r io.Reader) (image.Config, error),
+	decode func(r io.Reader) (image.Image, error),
+	checkTrailer func(tail []byte) error,
+) (image.Config, error) {
+	cfg, err := decodeConfig(io.NewSectionReader(r, 0, size))
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		return image.Config{}, fmt.Errorf("%w: incomplete header", errTruncated)
+	}
+	if err != nil {
+		return image.Config{}, fmt.Errorf("decoding header: %w", err)
+	}
+	tailSize := min(size, trailerSize)
+	tail, err := readAt(r, size-tailSize, tailSize)
+	if err != nil {
+		return image.Config{}, err
+	}
+	if err := checkTrailer(tail); err != nil {
+		return image.Config{}, err
+	}
+	if full {
+		if _, err := decode(io.NewSectionReader(r, 0, size)); errors.Is(err, io.ErrUnexpectedEOF) {
+			return image.Config{}, fmt.Errorf("%w: incomplete image data", errTruncated)
+		} else if err != nil {
+			return image.Config{}, fmt.Errorf("decoding image: %w", err)
+		}
+	}
+	return cfg, nil
+}
+
+var errTruncated = errors.New("image is truncated")
+
+// checkJPEGTrailer looks for the EOI marker, allowing for the padding some
+// cameras append after it.
+func checkJPEGTrailer(data []byte) error {
+	tail := bytes.TrimRight(data, "\x00\r\n ")
+	if !bytes.HasSuffix(tail, []byte{0xff, 0xd9}) {
+		return fmt.Errorf("%w: missing JPEG end of image marker", errTruncated)
+	}
+	return nil
+}
+
+func checkPNGTrailer(data []byte) error {
+	if !bytes.Contains(data[max(0, len(data)-32):], []byte("IEND")) {
+		return fmt.Errorf("%w: missing PNG IEND chunk", errTruncated)
+	}
+	return nil
+}
+
+func checkGIFTrailer(data []byte) error {
+	if !bytes.HasSuffix(data, []byte{0x3b}) {
+		return fmt.Errorf("%w: missing GIF trailer", errTruncated)
+	}
+	return nil
+}
+
+// webpHeaderSize covers the RIFF header and the first chunk header.
+const webpHeaderSize = 30
+
+// decodeWebP verifies the RIFF length against the size of the image and
+// reads the canvas size from the VP8, VP8L or VP8X chunk header in data, the
+// start of the image.
+func decodeWebP(data []byte, size int64) (image.Config, error) {
+	if len(data) < webpHeaderSize {
+		return image.Config{}, fmt.Errorf("%w: WebP header too short", errTruncated)
+	}
+	if riffSize := int64(binary.LittleEndian.Uint32(data[4:8])) + 8; riffSize > size {
+		return image.Config{}, fmt.Errorf("%w: WebP is %d bytes, header says %d", errTruncated, size, riffSize)
+	}
+
+	chunk := data[12:]
+	var width, height int
+	switch string(chunk[0:4]) {
+	case "VP8 ":
+		// frame tag (3 bytes), start code 9d 01 2a, then 14-bit dimensions
+		if !bytes.Equal(chunk[11:14], []byte{0x9d, 0x01, 0x2a}) {
+			return image.Config{}, errors.New("invalid WebP VP8 start code")
+		}
+		width = int(binary.LittleEndian.Uint16(chunk[14:16]) & 0x3fff)
+		height = int(binary.LittleEndian.Uint16(chunk[16:18]) & 0x3fff)
+	case "VP8L":
+		if chunk[8] != 0x2f {
+			return image.Config{}, errors.New("invalid WebP VP8L signature")
+		}
+		bits := binary.LittleEndian.Uint32(chunk[9:13])
+		width = int(bits&0x3fff) + 1
+		height = int(bits>>14&0x3fff) + 1
+	case "VP8X":
+		width = int(uint32(chunk[12])|uint32(chunk[13])<<8|uint32(chunk[14])<<16) + 1
+		height = int(uint32(chunk[15])|uint32(chunk[16])<<8|uint32(chunk[17])<<16) + 1
+	default:
+		return image.Config{}, fmt.Errorf("unknown WebP chunk %q", chunk[0:4])
+	}
+
+	return image.Config{Width: width, Height: height}, nil
+}
+
+// decodeAVIF reads the image size from the first ispe (image spatial extents)
+// property box in data, the start of the image.
+func decodeAVIF(data []byte) (image.Config, error) {
+	i := bytes.Index(data, []byte("ispe"))
+	if i < 0 || i+16 > len(data) {
+		return image.Config{}, fmt.Errorf("%w: AVIF image size not found", errTruncated)
+	}
+	// box type, version and flags (4 bytes), width, height
+	width := int(binary.BigEndian.Uint32(data[i+8 : i+12]))
+	height := int(binary.BigEndian.Uint32(data[i+12 : i+16]))
+	return image.Config{Width: width, Height: height}, nil
+}
@@ -0,0 +1,84 @@
+package imageformat
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 32, 24))
+	var jpegBuf, pngBuf bytes.Buffer
+	jpeg.Encode(&jpegBuf, img, nil)
+	png.Encode(&pngBuf, img)
+	jpegData := jpegBuf.Bytes()
+
+	// Corrupt the entropy-coded data while keeping header and EOI intact;
+	// only a full decode notices.
+	corrupt := bytes.Clone(jpegData)
+	sos := bytes.Index(corrupt, []byte{0xff, 0xda})
+	for i := sos + 16; i < len(corrupt)-2; i++ {
+		corrupt[i] = 0xff
+	}
+
+	tests := []struct {
+		name           string
+		data           []byte
+		format         Format
+		full           bool
+		expectedWidth  int
+		expectedHeight int
+		expectedError  string
+	}{
+		{name: "jpeg header", data: jpegData, format: JPEG, expectedWidth: 32, expectedHeight: 24},
+		{name: "jpeg full", data: jpegData, format: JPEG, full: true, expectedWidth: 32, expectedHeight: 24},
+		{name: "jpeg with padding", data: append(bytes.Clone(jpegData), 0, 0, 0), format: JPEG, expectedWidth: 32, expectedHeight: 24},
+		{name: "jpeg without eoi", data: jpegData[:len(jpegData)-2], format: JPEG, expectedError: "missing JPEG end of image marker"},
+		{name: "corrupt jpeg header only", data: corrupt, format: JPEG, expectedWidth: 32, expectedHeight: 24},
+		{name: "corrupt jpeg full", data: corrupt, format: JPEG, full: true, expectedError: "decoding image"},
+		{name: "png", data: pngBuf.Bytes(), format: PNG, full: true, expectedWidth: 32, expectedHeight: 24},
+		{name: "truncated png", data: pngBuf.Bytes()[:pngBuf.Len()-12], format: PNG, expectedError: "missing PNG IEND chunk"},
+		{
+			name:           "webp vp8x",
+			data:           []byte("RIFF\x1a\x00\x00\x00WEBPVP8X\x0a\x00\x00\x00\x00\x00\x00\x00\x7f\x07\x00\x37\x04\x00\x00\x00\x00\x00"),
+			format:         WebP,
+			expectedWidth:  1920,
+			expectedHeight: 1080,
+		},
+		{
+			name:          "truncated webp",
+			data:          []byte("RIFF\xff\x00\x00\x00WEBPVP8X\x0a\x00\x00\x00\x00\x00\x00\x00\x7f\x07\x00\x37\x04\x00\x00\x00\x00\x00"),
+			format:        WebP,
+			expectedError: "header says 263",
+		},
+		{
+			name:           "avif",
+			data:           []byte("\x00\x00\x00\x1cftypavif\x00\x00\x00\x00avifmif1miaf\x00\x00\x00\x14ispe\x00\x00\x00\x00\x00\x00\x02\x80\x00\x00\x01\xe0"),
+			format:         AVIF,
+			expectedWidth:  640,
+			expectedHeight: 480,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := Decode(tt.data, tt.format, tt.full)
+
+			if tt.expectedError != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.expectedError) {
+					t.Errorf("error = %v, want %v", err, tt.expectedError)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if cfg.Width != tt.expectedWidth || cfg.Height != tt.expectedHeight {
+				t.Errorf("size = %dx%d, want %dx%d", cfg.Width, cfg.Height, tt.expectedWidth, tt.expectedHeight)
+			}
+		})
+	}
+}
@@ -0,0 +1,235 @@
+// Package layout places snapshots in date partitions of the camera directory,
+// e.g. <camera>/2024/05/31/<unixnano>.jpg, so that a single directory does not
+// grow to hundreds of thousands of files.
+package layout
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/stone/timelapser/internal/imageformat"
+)
+
+// tokens are the supported placeholders in the order they must appear, from
+// the most to the least significant, so that the lexicographic order of the
+// partitions is their chronological order.
+var tokens = []struct{ token, format string }{
+	{"YYYY", "2006"},
+	{"MM", "01"},
+	{"DD", "02"},
+	{"HH", "15"},
+}
+
+// Layout maps the time of a snapshot to a partition directory. The zero
+// Layout is flat: all snapshots are stored in the camera directory.
+type Layout struct {
+	dirs []string // time format per path component
+}
+
+// Flat stores all snapshots directly in the camera directory.
+var Flat = Layout{}
+
+// Parse parses a layout such as "YYYY/MM/DD". Components are separated by
+// "/", tokens within a component by "-", e.g. "YYYY-MM/DD". An empty string
+// or "flat" returns Flat.
+func Parse(s string) (Layout, error) {
+	if s == "" || s == "flat" {
+		return Flat, nil
+	}
+
+	var l Layout
+	next := 0
+	for _, component := range strings.Split(s, "/") {
+		var formats []string
+		for _, token := range strings.Split(component, "-") {
+			if next == len(tokens) || token != tokens[next].token {
+				return Flat, fmt.Errorf("invalid layout %q: expected tokens in the order YYYY, MM, DD, HH", s)
+			}
+			formats = append(formats, tokens[next].format)
+			next++
+		}
+		l.dirs = append(l.dirs, strings.Join(formats, "-"))
+	}
+	return l, nil
+}
+
+// String returns the layout in the syntax accepted by Parse.
+func (l Layout) String() string {
+	if len(l.dirs) == 0 {
+		return "flat"
+	}
+	s := strings.Join(l.dirs, "/")
+	for _, t := range tokens {
+		s = strings.Replace(s, t.format, t.token, 1)
+	}
+	return s
+}
+
+// Dir returns the partition of a snapshot taken at t, relative to the camera
+// directory. Partitions use local time.
+func (l Layout) Dir(t time.Time) string {
+	parts := make([]string, len(l.dirs))
+	for i, format := range l.dirs {
+		parts[i] = t.Format(format)
+	}
+	return filepath.Join(parts...)
+}
+
+// walk calls fn with the path of every image in root and its partitions,
+// relative to root.
+func walk(root, rel string, fn func(rel string)) error {
+	entries, err := os.ReadDir(filepath.Join(root, rel))
+	if err != nil {
+		return fmt.Errorf("reading directory: %w", err)
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		switch {
+		case entry.IsDir() && IsPartition(name):
+			if err := walk(root, filepath.Join(rel, name), fn); err != nil {
+				return err
+			}
+		case !entry.IsDir() && imageformat.IsImage(name):
+			fn(filepath.Join(rel, name))
+		}
+	}
+	return nil
+}
+
+// markerName is the file in the camera directory that records the layout of
+// the last successful migration.
+const markerName = ".layout"
+
+// Migrate moves images named by their Unix nanosecond timestamp into the
+// partition l assigns them, e.g. from a flat camera directory after a layout
+// was configured, and removes partitions left empty. It returns the number of
+// moved images. Once a directory was migrated to l it is not walked again
+// until the layout changes.
+func (l Layout) Migrate(cameraDir string) (int, error) {
+	marker := filepath.Join(cameraDir, markerName)
+	if data, err := os.ReadFile(marker); err == nil && strings.TrimSpace(string(data)) == l.String() {
+		return 0, nil
+	}
+
+	var images []string
+	if err := walk(cameraDir, "", func(rel string) { images = append(images, rel) }); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	moved := 0
+	for _, rel := range images {
+		t, ok := FrameTime(filepath.Base(rel))
+		if !ok {
+			continue
+		}
+		target := filepath.Join(l.Dir(t), filepath.Base(rel))
+		if target == rel {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Join(cameraDir, filepath.Dir(target)), 0o755); err != nil {
+			return moved, fmt.Errorf("failed to create partition: %w", err)
+		}
+		if err := os.Rename(filepath.Join(cameraDir, rel), filepath.Join(cameraDir, target)); err != nil {
+			return moved, fmt.Errorf("failed to move %s: %w", rel, err)
+		}
+		moved++
+	}
+
+	if _, err := removeEmpty(cameraDir, ""); err != nil {
+		return moved, err
+	}
+	if err := os.WriteFile(marker, []byte(l.String()+"\n"), 0o644); err != nil {
+		return moved, fmt.Errorf("failed to record layout: %w", err)
+	}
+	return moved, nil
+}
+
+// removeEmpty reports whether rel is empty after removing its empty
+// partitions.
+func removeEmpty(root, rel string) (bool, error) {
+	entries, err := os.ReadDir(filepath.Join(root, rel))
+	if err != nil {
+		return false, fmt.Errorf("reading directory: %w", err)
+	}
+	empty := true
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() || !IsPartition(name) {
+			empty = false
+			continue
+		}
+		sub := filepath.Join(rel, name)
+		subEmpty, err := removeEmpty(root, sub)
+		if err != nil {
+			return false, err
+		}
+		if !subEmpty {
+			empty = false
+			continue
+		}
+		if err := os.Remove(filepath.Join(root, sub)); err != nil {
+			return false, fmt.Errorf("failed to remove partition: %w", err)
+		}
+	}
+	return empty, nil
+}
+
+// IsPartition reports whether a directory name can be a partition of some
+// layout: digits, optionally separated by "-". This excludes directories
+// such as rejected/.
+func IsPartition(name string) bool {
+	if name == "" || name[0] == '-' || name[len(name)-1] == '-' {
+		return false
+	}
+	for _, r := range name {
+		if (r < '0' || r > '9') && r != '-' {
+			return false
+		}
+	}
+	return true
+}
+
+// Overlaps reports whether the partition at path, relative to the camera
+// directory, may hold snapshots taken in [from, to). A zero from or to leaves
+// that end open. Partitions of any layout are recognized, since the digits of
+// a partition path are a prefix of the time formatted as YYYYMMDDHH.
+func Overlaps(path string, from, to time.Time) bool {
+	digits := strings.Map(func(r rune) rune {
+		if r < '0' || r > '9' {
+			return -1
+		}
+		return r
+	}, path)
+	const full = "2006010215"
+	if len(digits) > len(full) {
+		return true
+	}
+	if !from.IsZero() && digits < from.Format(full)[:len(digits)] {
+		return false
+	}
+	if !to.IsZero() && digits > to.Format(full)[:len(digits)] {
+		return false
+	}
+	return true
+}
+
+// FrameTime returns the time in the name of a snapshot, which is its Unix
+// nanosecond timestamp followed by an image extension.
+func FrameTime(name string) (time.Time, bool) {
+	if !imageformat.IsImage(name) {
+		return time.Time{}, false
+	}
+	n, err := strconv.ParseInt(strings.TrimSuffix(name, filepath.Ext(name)), 10, 64)
+	if err != nil || n <= 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(0, n), true
+}
@@ -0,0 +1,180 @@
+package layout
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	taken := time.Date(2024, 5, 31, 7, 30, 0, 0, time.Local)
+
+	tests := []struct {
+		layout        string
+		expectedDir   string
+		expectedError bool
+	}{
+		{layout: "", expectedDir: ""},
+		{layout: "flat", expectedDir: ""},
+		{layout: "YYYY/MM/DD", expectedDir: "2024/05/31"},
+		{layout: "YYYY-MM/DD/HH", expectedDir: "2024-05/31/07"},
+		{layout: "YYYY-MM-DD", expectedDir: "2024-05-31"},
+		{layout: "YYYY", expectedDir: "2024"},
+		{layout: "DD/MM/YYYY", expectedError: true},
+		{layout: "YYYY/DD", expectedError: true},
+		{layout: "YYYY/MM/DD/HH/MM", expectedError: true},
+		{layout: "YYYY//MM", expectedError: true},
+		{layout: "%Y/%m/%d", expectedError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.layout, func(t *testing.T) {
+			l, err := Parse(tt.layout)
+			if tt.expectedError {
+				if err == nil {
+					t.Fatalf("Parse(%q) expected an error", tt.layout)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse(%q) error = %v", tt.layout, err)
+			}
+			if dir := filepath.ToSlash(l.Dir(taken)); dir != tt.expectedDir {
+				t.Errorf("Dir() = %q, want %q", dir, tt.expectedDir)
+			}
+			if s := l.String(); s != tt.layout && !(s == "flat" && tt.layout == "") {
+				t.Errorf("String() = %q, want %q", s, tt.layout)
+			}
+		})
+	}
+}
+
+// writeImages creates empty images named by the Unix nanosecond timestamp of
+// each time, in the partition of l.
+func writeImages(t *testing.T, dir string, l Layout, times ...time.Time) []string {
+	t.Helper()
+	var names []string
+	for _, taken := range times {
+		rel := filepath.Join(l.Dir(taken), fmt.Sprintf("%d.jpg", taken.UnixNano()))
+		if err := os.MkdirAll(filepath.Join(dir, filepath.Dir(rel)), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, rel), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, rel)
+	}
+	return names
+}
+
+// images returns the images below dir as walk finds them.
+func images(t *testing.T, dir string) []string {
+	t.Helper()
+	var names []string
+	if err := walk(dir, "", func(rel string) { names = append(names, rel) }); err != nil {
+		t.Fatal(err)
+	}
+	return names
+}
+
+func TestMigrate(t *testing.T) {
+	dir := t.TempDir()
+	hourly, _ := Parse("YYYY-MM-DD/HH")
+	daily, _ := Parse("YYYY/MM/DD")
+	day := time.Date(2024, 5, 31, 12, 0, 0, 0, time.Local)
+
+	writeImages(t, dir, Flat, day)
+	writeImages(t, dir, hourly, day.Add(time.Hour))
+	if err := os.WriteFile(filepath.Join(dir, "cover.jpg"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	moved, err := daily.Migrate(dir)
+	if err != nil {
+		t.Fatalf("Migrate() error = %v", err)
+	}
+	if moved != 2 {
+		t.Errorf("Migrate() moved %d, want 2", moved)
+	}
+
+	expected := []string{
+		filepath.Join("2024", "05", "31", fmt.Sprintf("%d.jpg", day.UnixNano())),
+		filepath.Join("2024", "05", "31", fmt.Sprintf("%d.jpg", day.Add(time.Hour).UnixNano())),
+		"cover.jpg", // not a snapshot, left alone
+	}
+	if got := images(t, dir); !reflect.DeepEqual(got, expected) {
+		t.Errorf("images = %v, want %v", got, expected)
+	}
+	if _, err := os.Stat(filepath.Join(dir, hourly.Dir(day.Add(time.Hour)))); !os.IsNotExist(err) {
+		t.Errorf("old partition not removed: %v", err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "rejected"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	// Back to flat.
+	if moved, err := Flat.Migrate(dir); err != nil || moved != 2 {
+		t.Fatalf("Migrate() = %d, %v, want 2", moved, err)
+	}
+	// The emptied partitions are removed, other directories are kept, next
+	// to the layout marker.
+	if entries, _ := os.ReadDir(dir); len(entries) != 5 {
+		t.Errorf("flat directory has %d entries, want 5", len(entries))
+	}
+
+	// An unchanged layout is not walked again.
+	writeImages(t, dir, hourly, day.Add(2*time.Hour))
+	if moved, err := Flat.Migrate(dir); err != nil || moved != 0 {
+		t.Errorf("Migrate() with unchanged layout = %d, %v, want 0", moved, err)
+	}
+	if moved, err := daily.Migrate(dir); err != nil || moved != 3 {
+		t.Errorf("Migrate() after a layout change = %d, %v, want 3", moved, err)
+	}
+
+	if moved, err := daily.Migrate(filepath.Join(dir, "missing")); err != nil || moved != 0 {
+		t.Errorf("Migrate() of a missing directory = %d, %v", moved, err)
+	}
+}
+
+func TestOverlaps(t *testing.T) {
+	from := time.Date(2024, 5, 30, 22, 0, 0, 0, time.Local)
+	to := time.Date(2024, 6, 2, 8, 0, 0, 0, time.Local)
+
+	tests := []struct {
+		path     string
+		from, to time.Time
+		expected bool
+	}{
+		{path: "", from: from, to: to, expected: true},
+		{path: "2024", from: from, to: to, expected: true},
+		{path: "2023", from: from, to: to, expected: false},
+		{path: "2024/05/29", from: from, to: to, expected: false},
+		{path: "2024/05/30", from: from, to: to, expected: true},
+		{path: "2024-05-30/21", from: from, to: to, expected: false},
+		{path: "2024-06/02", from: from, to: to, expected: true},
+		{path: "2024/06/03", from: from, to: to, expected: false},
+		{path: "2024/06/03", from: from, expected: true},
+		{path: "2023/01/01", to: to, expected: true},
+	}
+
+	for _, tt := range tests {
+		if got := Overlaps(filepath.FromSlash(tt.path), tt.from, tt.to); got != tt.expected {
+			t.Errorf("Overlaps(%q) = %v, want %v", tt.path, got, tt.expected)
+		}
+	}
+}
+
+func TestFrameTime(t *testing.T) {
+	taken := time.Date(2024, 5, 31, 12, 0, 0, 42, time.Local)
+	if got, ok := FrameTime(fmt.Sprintf("%d.jpg", taken.UnixNano())); !ok || !got.Equal(taken) {
+		t.Errorf("FrameTime() = %v, %v, want %v", got, ok, taken)
+	}
+	for _, name := range []string{"cover.jpg", fmt.Sprintf("%d.txt", taken.UnixNano()), "-1.jpg"} {
+		if _, ok := FrameTime(name); ok {
+			t.Errorf("FrameTime(%q) ok, want not a snapshot", name)
+		}
+	}
+}
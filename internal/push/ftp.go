@@ -0,0 +1,446 @@
+package push
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stone/timelapser/internal/camera"
+	"github.com/stone/timelapser/internal/config"
+	"github.com/stone/timelapser/internal/imageformat"
+	"github.com/stone/timelapser/internal/snapshot"
+	"github.com/stone/timelapser/internal/storage"
+	"github.com/stone/timelapser/internal/utils"
+)
+
+// FTPCameraType is the camera type of cameras that upload over FTP.
+const FTPCameraType = "ftp"
+
+const (
+	ftpIdleTimeout = 5 * time.Minute  // control connection without commands
+	ftpDataTimeout = 30 * time.Second // waiting for and transferring over a data connection
+	ftpMaxLine     = 4096
+)
+
+// IsUploadCamera reports whether cam uploads its snapshots instead of being
+// polled.
+func IsUploadCamera(cam *config.CameraConfig) bool {
+	return cam.Type == CameraType || cam.Type == FTPCameraType
+}
+
+// FTPServer is a minimal FTP server that stores images uploaded by ftp
+// cameras. Only passive mode is supported, directories are virtual and files
+// are stored under timestamp names like polled snapshots.
+type FTPServer struct {
+	listen     string
+	publicHost net.IP
+	minPort    int
+	maxPort    int
+	store      storage.Storage
+	maxSize    int64
+	lock       Locker
+	logger     *slog.Logger
+	cameras    map[string]*config.CameraConfig // username → camera
+}
+
+// NewFTPServer returns a server for the ftp cameras in cfg. Every ftp camera
+// needs a password.
+func NewFTPServer(cfg *config.Config, store storage.Storage, lock Locker, logger *slog.Logger) (*FTPServer, error) {
+	s := &FTPServer{
+		listen:  cfg.FTP.Listen,
+		store:   store,
+		maxSize: cfg.FTP.MaxSize,
+		lock:    lock,
+		logger:  logger,
+		cameras: make(map[string]*config.CameraConfig),
+	}
+	if s.maxSize <= 0 {
+		s.maxSize = defaultMaxSize
+	}
+	if cfg.FTP.PublicHost != "" {
+		if s.publicHost = net.ParseIP(cfg.FTP.PublicHost).To4(); s.publicHost == nil {
+			return nil, fmt.Errorf("ftp publicHost %q is not an IPv4 address", cfg.FTP.PublicHost)
+		}
+	}
+	if cfg.FTP.PassivePorts != "" {
+		var err error
+		if s.minPort, s.maxPort, err = parsePortRange(cfg.FTP.PassivePorts); err != nil {
+			return nil, err
+		}
+	}
+
+	for i := range cfg.Cameras {
+		cam := &cfg.Cameras[i]
+		if cam.Type != FTPCameraType {
+			continue
+		}
+		if cam.FTP.Password == "" {
+			return nil, fmt.Errorf("camera %s: ftp camera requires ftp.password", cam.Name)
+		}
+		username := FTPUsername(cam)
+		if other, ok := s.cameras[username]; ok {
+			return nil, fmt.Errorf("camera %s: ftp username %s already used by camera %s", cam.Name, username, other.Name)
+		}
+		s.cameras[username] = cam
+	}
+	return s, nil
+}
+
+// FTPUsername returns the FTP login of an ftp camera.
+func FTPUsername(cam *config.CameraConfig) string {
+	if cam.FTP.Username != "" {
+		return cam.FTP.Username
+	}
+	return utils.ToCamelCase(cam.Name)
+}
+
+func parsePortRange(s string) (int, int, error) {
+	lo, hi, found := strings.Cut(s, "-")
+	if !found {
+		hi = lo
+	}
+	minPort, err1 := strconv.Atoi(strings.TrimSpace(lo))
+	maxPort, err2 := strconv.Atoi(strings.TrimSpace(hi))
+	if err1 != nil || err2 != nil || minPort < 1 || maxPort > 65535 || minPort > maxPort {
+		return 0, 0, fmt.Errorf("invalid ftp passivePorts %q, want e.g. 30000-30009", s)
+	}
+	return minPort, maxPort, nil
+}
+
+// ListenAndServe accepts FTP connections until ctx is cancelled.
+func (s *FTPServer) ListenAndServe(ctx context.Context) error {
+	ln, err := net.Listen("tcp", s.listen)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ctx, ln)
+}
+
+// Serve accepts FTP connections on ln until ctx is cancelled. Open sessions
+// are closed on return.
+func (s *FTPServer) Serve(ctx context.Context, ln net.Listener) error {
+	stop := context.AfterFunc(ctx, func() { ln.Close() })
+	defer stop()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			closeConn := context.AfterFunc(ctx, func() { conn.Close() })
+			defer closeConn()
+			s.newSession(ctx, conn).serve()
+		}()
+	}
+}
+
+// ftpSession is the state of one control connection.
+type ftpSession struct {
+	ctx      context.Context // cancelled when the server stops
+	server   *FTPServer
+	conn     net.Conn
+	reader   *bufio.Reader
+	logger   *slog.Logger
+	username string
+	camera   *config.CameraConfig // set after a successful login
+	dir      string               // virtual working directory
+	passive  net.Listener
+}
+
+func (s *FTPServer) newSession(ctx context.Context, conn net.Conn) *ftpSession {
+	return &ftpSession{
+		ctx:    ctx,
+		server: s,
+		conn:   conn,
+		reader: bufio.NewReaderSize(conn, ftpMaxLine),
+		logger: s.logger.With("remote", conn.RemoteAddr().String()),
+		dir:    "/",
+	}
+}
+
+func (c *ftpSession) serve() {
+	defer c.conn.Close()
+	defer c.closePassive()
+
+	c.reply(220, "timelapser FTP ready")
+	for {
+		c.conn.SetReadDeadline(time.Now().Add(ftpIdleTimeout))
+		line, err := c.reader.ReadSlice('\n')
+		if err != nil {
+			if errors.Is(err, bufio.ErrBufferFull) {
+				c.reply(500, "Line too long")
+			}
+			return
+		}
+		cmd, arg, _ := strings.Cut(strings.TrimRight(string(line), "\r\n"), " ")
+		cmd = strings.ToUpper(cmd)
+		if cmd == "PASS" {
+			c.logger.Debug("FTP command", "cmd", cmd)
+		} else {
+			c.logger.Debug("FTP command", "cmd", cmd, "arg", arg)
+		}
+		if !c.handle(cmd, arg) {
+			return
+		}
+	}
+}
+
+// handle executes one command and reports whether the session continues.
+func (c *ftpSession) handle(cmd, arg string) bool {
+	switch cmd {
+	case "USER":
+		c.username, c.camera = arg, nil
+		c.reply(331, "Password required")
+		return true
+	case "PASS":
+		c.login(arg)
+		return true
+	case "QUIT":
+		c.reply(221, "Goodbye")
+		return false
+	case "SYST":
+		c.reply(215, "UNIX Type: L8")
+		return true
+	case "FEAT":
+		c.replyLines(211, "Features:", "EPSV", "PASV", "UTF8", "End")
+		return true
+	case "OPTS":
+		c.reply(200, "OK")
+		return true
+	case "NOOP":
+		c.reply(200, "OK")
+		return true
+	}
+
+	if c.camera == nil {
+		c.reply(530, "Please login with USER and PASS")
+		return true
+	}
+
+	switch cmd {
+	case "PWD", "XPWD":
+		c.reply(257, fmt.Sprintf("%q is the current directory", c.dir))
+	case "CWD", "XCWD":
+		c.dir = c.resolve(arg)
+		c.reply(250, "Directory changed")
+	case "CDUP", "XCUP":
+		c.dir = path.Dir(c.dir)
+		c.reply(250, "Directory changed")
+	case "MKD", "XMKD":
+		// Cameras create dated directories; they all map to the camera directory.
+		c.reply(257, fmt.Sprintf("%q created", c.resolve(arg)))
+	case "RMD", "XRMD", "DELE":
+		c.reply(250, "OK")
+	case "RNFR":
+		c.reply(350, "Ready for RNTO")
+	case "RNTO":
+		c.reply(250, "OK")
+	case "TYPE", "MODE", "STRU":
+		c.reply(200, "OK")
+	case "ALLO":
+		c.reply(202, "Not needed")
+	case "SIZE", "MDTM", "RETR":
+		c.reply(550, "File not available")
+	case "PASV":
+		c.enterPassive(false)
+	case "EPSV":
+		c.enterPassive(true)
+	case "PORT", "EPRT":
+		c.reply(502, "Active mode not supported, use passive mode")
+	case "LIST", "NLST", "MLSD":
+		c.list()
+	case "STOR", "APPE", "STOU":
+		c.store(arg)
+	default:
+		c.reply(502, "Command not implemented")
+	}
+	return true
+}
+
+func (c *ftpSession) login(password string) {
+	cam, ok := c.server.cameras[c.username]
+	if !ok || subtle.ConstantTimeCompare([]byte(password), []byte(cam.FTP.Password)) != 1 {
+		c.logger.Warn("FTP login failed", "username", c.username)
+		c.reply(530, "Login incorrect")
+		return
+	}
+	c.camera = cam
+	c.logger = c.logger.With("name", cam.Name)
+	c.reply(230, "Logged in")
+}
+
+func (c *ftpSession) resolve(p string) string {
+	if !path.IsAbs(p) {
+		p = path.Join(c.dir, p)
+	}
+	return path.Clean(p)
+}
+
+// enterPassive opens a listener for the next data connection.
+func (c *ftpSession) enterPassive(extended bool) {
+	c.closePassive()
+
+	ln, err := c.server.listenPassive(c.conn.LocalAddr().(*net.TCPAddr).IP)
+	if err != nil {
+		c.logger.Error("FTP passive listen failed", "error", err)
+		c.reply(425, "Cannot open data connection")
+		return
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+
+	if extended {
+		c.passive = ln
+		c.reply(229, fmt.Sprintf("Entering Extended Passive Mode (|||%d|)", port))
+		return
+	}
+
+	host := c.server.publicHost
+	if host == nil {
+		host = c.conn.LocalAddr().(*net.TCPAddr).IP.To4()
+	}
+	if host == nil {
+		ln.Close()
+		c.reply(425, "PASV requires IPv4, use EPSV")
+		return
+	}
+	c.passive = ln
+	c.reply(227, fmt.Sprintf("Entering Passive Mode (%d,%d,%d,%d,%d,%d)",
+		host[0], host[1], host[2], host[3], port>>8, port&0xff))
+}
+
+// listenPassive listens for a data connection on ip, the address the client
+// reached the control connection on, so passive ports are not opened on other
+// interfaces.
+func (s *FTPServer) listenPassive(ip net.IP) (net.Listener, error) {
+	host := ip.String()
+	if s.minPort == 0 {
+		return net.Listen("tcp", net.JoinHostPort(host, "0"))
+	}
+	// Start at a random port so concurrent sessions rarely collide.
+	n := s.maxPort - s.minPort + 1
+	offset := rand.IntN(n)
+	var err error
+	for i := range n {
+		port := s.minPort + (offset+i)%n
+		var ln net.Listener
+		if ln, err = net.Listen("tcp", net.JoinHostPort(host, strconv.Itoa(port))); err == nil {
+			return ln, nil
+		}
+	}
+	return nil, fmt.Errorf("no free passive port in %d-%d: %w", s.minPort, s.maxPort, err)
+}
+
+func (c *ftpSession) closePassive() {
+	if c.passive != nil {
+		c.passive.Close()
+		c.passive = nil
+	}
+}
+
+// openData accepts the data connection of a transfer. Only the client of the
+// control connection may connect.
+func (c *ftpSession) openData() (net.Conn, error) {
+	if c.passive == nil {
+		return nil, errors.New("use PASV or EPSV first")
+	}
+	ln := c.passive.(*net.TCPListener)
+	defer c.closePassive()
+
+	ln.SetDeadline(time.Now().Add(ftpDataTimeout))
+	conn, err := ln.Accept()
+	if err != nil {
+		return nil, err
+	}
+	want := c.conn.RemoteAddr().(*net.TCPAddr).IP
+	if got := conn.RemoteAddr().(*net.TCPAddr).IP; !got.Equal(want) {
+		conn.Close()
+		return nil, fmt.Errorf("data connection from %s, expected %s", got, want)
+	}
+	conn.SetDeadline(time.Now().Add(ftpDataTimeout))
+	return conn, nil
+}
+
+// list sends an empty listing; uploaded files are not exposed over FTP.
+func (c *ftpSession) list() {
+	conn, err := c.openData()
+	if err != nil {
+		c.reply(425, err.Error())
+		return
+	}
+	c.reply(150, "Data connection open")
+	conn.Close()
+	c.reply(226, "Transfer complete")
+}
+
+func (c *ftpSession) store(name string) {
+	// The data connection is accepted before the preliminary reply, so a
+	// failure is answered with 425 alone.
+	conn, err := c.openData()
+	if err != nil {
+		c.reply(425, err.Error())
+		return
+	}
+	c.reply(150, "Data connection open")
+	data, err := io.ReadAll(io.LimitReader(conn, c.server.maxSize+1))
+	conn.Close()
+	if err != nil {
+		c.reply(426, "Transfer aborted")
+		return
+	}
+	if int64(len(data)) > c.server.maxSize {
+		c.logger.Warn("FTP upload too large", "file", name, "limit", c.server.maxSize)
+		c.reply(552, "File too large")
+		return
+	}
+
+	// Cameras also upload videos and test files, which are not snapshots.
+	if !imageformat.IsImage(name) {
+		c.logger.Debug("FTP upload ignored, not an image", "file", name, "bytes", len(data))
+		c.reply(226, "Transfer complete")
+		return
+	}
+
+	frame := &camera.Frame{Data: data, Time: time.Now()}
+	err = storeFrame(c.ctx, c.server.lock, frame, c.camera, c.server.store, c.server.logger)
+	switch {
+	case errors.Is(err, snapshot.ErrRejected):
+		c.reply(550, "Not a valid image")
+	case err != nil:
+		c.logger.Error("Error storing FTP upload", "file", name, "error", err)
+		c.reply(451, "Failed to store file")
+	default:
+		c.reply(226, "Transfer complete")
+	}
+}
+
+func (c *ftpSession) reply(code int, msg string) {
+	fmt.Fprintf(c.conn, "%d %s\r\n", code, msg)
+}
+
+// replyLines sends a multi-line reply, the last line closes it.
+func (c *ftpSession) replyLines(code int, first string, lines ...string) {
+	fmt.Fprintf(c.conn, "%d-%s\r\n", code, first)
+	for _, line := range lines[:len(lines)-1] {
+		fmt.Fprintf(c.conn, " %s\r\n", line)
+	}
+	fmt.Fprintf(c.conn, "%d %s\r\n", code, lines[len(lines)-1])
+}
@@ -0,0 +1,220 @@
+package push
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stone/timelapser/internal/config"
+	"github.com/stone/timelapser/internal/storage"
+)
+
+// ftpClient is a minimal passive mode FTP client for tests.
+type ftpClient struct {
+	t    *testing.T
+	conn *textproto.Conn
+}
+
+func dialFTP(t *testing.T, addr string) *ftpClient {
+	t.Helper()
+	conn, err := textproto.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	c := &ftpClient{t: t, conn: conn}
+	c.expect(220)
+	return c
+}
+
+func (c *ftpClient) expect(code int) string {
+	c.t.Helper()
+	_, msg, err := c.conn.ReadResponse(code)
+	if err != nil {
+		c.t.Fatalf("expected %d: %v", code, err)
+	}
+	return msg
+}
+
+func (c *ftpClient) cmd(code int, format string, args ...any) string {
+	c.t.Helper()
+	if err := c.conn.PrintfLine(format, args...); err != nil {
+		c.t.Fatalf("sending command: %v", err)
+	}
+	return c.expect(code)
+}
+
+// stor uploads data in extended passive mode and returns the final reply code.
+func (c *ftpClient) stor(name string, data []byte) int {
+	c.t.Helper()
+	msg := c.cmd(229, "EPSV")
+	var port int
+	if _, err := fmt.Sscanf(msg[strings.Index(msg, "|||"):], "|||%d|)", &port); err != nil {
+		c.t.Fatalf("parsing EPSV reply %q: %v", msg, err)
+	}
+	dataConn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		c.t.Fatalf("data connection: %v", err)
+	}
+	c.cmd(150, "STOR %s", name)
+	dataConn.Write(data)
+	dataConn.Close()
+
+	code, _, err := c.conn.ReadResponse(0)
+	if err != nil && code == 0 {
+		c.t.Fatalf("reading STOR reply: %v", err)
+	}
+	return code
+}
+
+func startFTPServer(t *testing.T, cfg *config.Config) string {
+	t.Helper()
+	var mu sync.Mutex
+	server, err := NewFTPServer(cfg, storage.NewLocal(cfg.OutputDir), func(string) *sync.Mutex { return &mu }, discardLogger)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		server.Serve(ctx, ln)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return ln.Addr().String()
+}
+
+func TestFTPServer(t *testing.T) {
+	outdir := t.TempDir()
+	addr := startFTPServer(t, &config.Config{
+		OutputDir: outdir,
+		Cameras: []config.CameraConfig{
+			{Name: "Drive way", Type: FTPCameraType, FTP: config.CameraFTPConfig{Password: "secret"}},
+		},
+	})
+	jpegData := encodeJPEG(t)
+
+	c := dialFTP(t, addr)
+	c.cmd(530, "PWD")
+	c.cmd(331, "USER driveWay")
+	c.cmd(530, "PASS wrong")
+	c.cmd(331, "USER driveWay")
+	c.cmd(230, "PASS secret")
+	c.cmd(257, "MKD 2026")
+	c.cmd(250, "CWD 2026")
+	if msg := c.cmd(257, "PWD"); !strings.Contains(msg, `"/2026"`) {
+		t.Errorf("PWD = %q", msg)
+	}
+	c.cmd(200, "TYPE I")
+	c.cmd(502, "PORT 127,0,0,1,4,1")
+	// Without a data connection there is no preliminary 150 reply.
+	c.cmd(425, "STOR nopasv.jpg")
+	c.cmd(200, "NOOP")
+
+	if code := c.stor("Drive way_00_20261018120000.jpg", jpegData); code != 226 {
+		t.Errorf("STOR image = %d, want 226", code)
+	}
+	if code := c.stor("Drive way_00_20261018120000.mp4", []byte("video")); code != 226 {
+		t.Errorf("STOR video = %d, want 226", code)
+	}
+	if code := c.stor("broken.jpg", []byte("not an image")); code != 550 {
+		t.Errorf("STOR invalid image = %d, want 550", code)
+	}
+	c.cmd(221, "QUIT")
+
+	entries, _ := os.ReadDir(filepath.Join(outdir, "driveWay"))
+	var images []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			images = append(images, entry.Name())
+		}
+	}
+	if len(images) != 1 || !strings.HasSuffix(images[0], ".jpg") {
+		t.Errorf("stored files = %v, want one .jpg", images)
+	}
+}
+
+func TestFTPServer_pasv(t *testing.T) {
+	addr := startFTPServer(t, &config.Config{
+		OutputDir: t.TempDir(),
+		FTP:       config.FTPConfig{PassivePorts: "30100-30109", PublicHost: "192.0.2.10"},
+		Cameras: []config.CameraConfig{
+			{Name: "cam", Type: FTPCameraType, FTP: config.CameraFTPConfig{Username: "reolink", Password: "x"}},
+		},
+	})
+
+	c := dialFTP(t, addr)
+	c.cmd(331, "USER reolink")
+	c.cmd(230, "PASS x")
+	msg := c.cmd(227, "PASV")
+
+	var h1, h2, h3, h4, p1, p2 int
+	if _, err := fmt.Sscanf(msg[strings.Index(msg, "("):], "(%d,%d,%d,%d,%d,%d)", &h1, &h2, &h3, &h4, &p1, &p2); err != nil {
+		t.Fatalf("parsing PASV reply %q: %v", msg, err)
+	}
+	if host := fmt.Sprintf("%d.%d.%d.%d", h1, h2, h3, h4); host != "192.0.2.10" {
+		t.Errorf("host = %s, want 192.0.2.10", host)
+	}
+	if port := p1<<8 | p2; port < 30100 || port > 30109 {
+		t.Errorf("port = %d, want within 30100-30109", port)
+	}
+}
+
+func TestFTPServer_listenPassive(t *testing.T) {
+	for _, server := range []*FTPServer{{}, {minPort: 30110, maxPort: 30119}} {
+		ln, err := server.listenPassive(net.IPv4(127, 0, 0, 1))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		ln.Close()
+		if ip := ln.Addr().(*net.TCPAddr).IP; !ip.Equal(net.IPv4(127, 0, 0, 1)) {
+			t.Errorf("passive listener on %s, want 127.0.0.1", ip)
+		}
+	}
+}
+
+func TestNewFTPServer_invalid(t *testing.T) {
+	tests := []struct {
+		config        config.Config
+		expectedError string
+	}{
+		{
+			config:        config.Config{Cameras: []config.CameraConfig{{Name: "a", Type: FTPCameraType}}},
+			expectedError: "camera a: ftp camera requires ftp.password",
+		},
+		{
+			config: config.Config{Cameras: []config.CameraConfig{
+				{Name: "a", Type: FTPCameraType, FTP: config.CameraFTPConfig{Username: "cam", Password: "x"}},
+				{Name: "b", Type: FTPCameraType, FTP: config.CameraFTPConfig{Username: "cam", Password: "y"}},
+			}},
+			expectedError: "camera b: ftp username cam already used by camera a",
+		},
+		{
+			config:        config.Config{FTP: config.FTPConfig{PassivePorts: "30010-30000"}},
+			expectedError: `invalid ftp passivePorts "30010-30000", want e.g. 30000-30009`,
+		},
+		{
+			config:        config.Config{FTP: config.FTPConfig{PublicHost: "nas.local"}},
+			expectedError: `ftp publicHost "nas.local" is not an IPv4 address`,
+		},
+	}
+	for _, tt := range tests {
+		_, err := NewFTPServer(&tt.config, nil, nil, discardLogger)
+		if err == nil || err.Error() != tt.expectedError {
+			t.Errorf("error = %v, want %v", err, tt.expectedError)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+// Package push receives snapshots that cameras upload over HTTP.
+package push
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stone/timelapser/internal/camera"
+	"github.com/stone/timelapser/internal/config"
+	"github.com/stone/timelapser/internal/snapshot"
+	"github.com/stone/timelapser/internal/storage"
+	"github.com/stone/timelapser/internal/utils"
+)
+
+// CameraType is the camera type of cameras that upload their snapshots.
+const CameraType = "push"
+
+// defaultMaxSize limits uploads unless push.maxSize is set.
+const defaultMaxSize = 20 << 20
+
+// Locker returns the mutex guarding a camera directory, shared with the
+// timelapse job so uploads are not written while images are collected.
+type Locker func(name string) *sync.Mutex
+
+// Handler stores uploads of push cameras, one path per camera.
+type Handler struct {
+	store   storage.Storage
+	maxSize int64
+	lock    Locker
+	logger  *slog.Logger
+	cameras map[string]*config.CameraConfig // upload path → camera
+}
+
+// NewHandler returns a handler for the push cameras in cfg. Every push camera
+// needs a token.
+func NewHandler(cfg *config.Config, store storage.Storage, lock Locker, logger *slog.Logger) (*Handler, error) {
+	h := &Handler{
+		store:   store,
+		maxSize: cfg.Push.MaxSize,
+		lock:    lock,
+		logger:  logger,
+		cameras: make(map[string]*config.CameraConfig),
+	}
+	if h.maxSize <= 0 {
+		h.maxSize = defaultMaxSize
+	}
+
+	for i := range cfg.Cameras {
+		cam := &cfg.Cameras[i]
+		if cam.Type != CameraType {
+			continue
+		}
+		if cam.Push.Token == "" {
+			return nil, fmt.Errorf("camera %s: push camera requires push.token", cam.Name)
+		}
+		path := Path(cam)
+		if other, ok := h.cameras[path]; ok {
+			return nil, fmt.Errorf("camera %s: push path %s already used by camera %s", cam.Name, path, other.Name)
+		}
+		h.cameras[path] = cam
+	}
+	return h, nil
+}
+
+// Path returns the upload path of a push camera.
+func Path(cam *config.CameraConfig) string {
+	if cam.Push.Path != "" {
+		return "/" + strings.TrimPrefix(cam.Push.Path, "/")
+	}
+	return "/push/" + utils.ToCamelCase(cam.Name)
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	cam, ok := h.cameras[r.URL.Path]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost && r.Method != http.MethodPut {
+		w.Header().Set("Allow", "POST, PUT")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !authorized(r, cam.Push.Token) {
+		h.logger.Warn("Unauthorized snapshot upload", "name", cam.Name, "remote", r.RemoteAddr)
+		w.Header().Set("WWW-Authenticate", `Bearer realm="timelapser"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	frame, err := h.readFrame(w, r)
+	if err != nil {
+		h.logger.Warn("Invalid snapshot upload", "name", cam.Name, "remote", r.RemoteAddr, "err", err)
+		status := http.StatusBadRequest
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			status = http.StatusRequestEntityTooLarge
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	err = storeFrame(r.Context(), h.lock, frame, cam, h.store, h.logger)
+	switch {
+	case errors.Is(err, snapshot.ErrRejected):
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+	case err != nil:
+		h.logger.Error("Error storing snapshot upload", "name", cam.Name, "error", err)
+		http.Error(w, "failed to store snapshot", http.StatusInternalServerError)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// storeFrame writes an uploaded frame while holding the camera lock.
+func storeFrame(ctx context.Context, lock Locker, frame *camera.Frame, cam *config.CameraConfig, store storage.Storage, logger *slog.Logger) error {
+	mu := lock(cam.Name)
+	mu.Lock()
+	defer mu.Unlock()
+	return snapshot.StoreFrame(ctx, frame, cam, store, logger)
+}
+
+// authorized checks the bearer token, or the token query parameter for
+// cameras that cannot set headers.
+func authorized(r *http.Request, token string) bool {
+	got := r.URL.Query().Get("token")
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		scheme, value, _ := strings.Cut(auth, " ")
+		if !strings.EqualFold(scheme, "Bearer") {
+			return false
+		}
+		got = value
+	}
+	return subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
+
+// readFrame reads the image from the request body, or from the first file of
+// a multipart/form-data upload.
+func (h *Handler) readFrame(w http.ResponseWriter, r *http.Request) (*camera.Frame, error) {
+	body := http.MaxBytesReader(w, r.Body, h.maxSize)
+	contentType := r.Header.Get("Content-Type")
+
+	var reader io.Reader = body
+	if mediaType, params, err := mime.ParseMediaType(contentType); err == nil && mediaType == "multipart/form-data" {
+		part, err := firstFilePart(body, params["boundary"])
+		if err != nil {
+			return nil, err
+		}
+		reader = part
+		contentType = part.Header.Get("Content-Type")
+	}
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("error reading upload: %w", err)
+	}
+	if len(data) == 0 {
+		return nil, errors.New("empty upload")
+	}
+	return &camera.Frame{Data: data, ContentType: contentType, Time: time.Now()}, nil
+}
+
+func firstFilePart(body io.Reader, boundary string) (*multipart.Part, error) {
+	if boundary == "" {
+		return nil, errors.New("multipart upload without boundary")
+	}
+	mr := multipart.NewReader(body, boundary)
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return nil, errors.New("multipart upload without file")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading upload: %w", err)
+		}
+		if part.FileName() != "" {
+			return part, nil
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package push
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stone/timelapser/internal/config"
+	"github.com/stone/timelapser/internal/storage"
+)
+
+var discardLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+func encodeJPEG(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, 64, 48)), nil); err != nil {
+		t.Fatalf("encoding jpeg: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func multipartBody(t *testing.T, data []byte) (string, []byte) {
+	t.Helper()
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	mw.WriteField("camera", "1")
+	part, _ := mw.CreateFormFile("image", "snapshot.jpg")
+	part.Write(data)
+	mw.Close()
+	return mw.FormDataContentType(), buf.Bytes()
+}
+
+func newTestHandler(t *testing.T, outdir string) *Handler {
+	t.Helper()
+	var mu sync.Mutex
+	handler, err := NewHandler(&config.Config{
+		OutputDir: outdir,
+		Push:      config.PushConfig{MaxSize: 1024},
+		Cameras: []config.CameraConfig{
+			{Name: "Front door", Type: CameraType, Push: config.CameraPushConfig{Token: "secret"}},
+			{Name: "Garden", Type: CameraType, Push: config.CameraPushConfig{Path: "upload/garden", Token: "other"}},
+			{Name: "Polled", SnapshotURL: "http://cam.local/snapshot.jpg"},
+		},
+	}, storage.NewLocal(outdir), func(string) *sync.Mutex { return &mu }, discardLogger)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return handler
+}
+
+func TestHandler(t *testing.T) {
+	jpegData := encodeJPEG(t)
+	contentType, multipartData := multipartBody(t, jpegData)
+
+	tests := []struct {
+		name           string
+		method         string
+		target         string
+		header         map[string]string
+		body           []byte
+		expectedStatus int
+		expectedDir    string // camera directory that receives a file
+	}{
+		{
+			name:           "bearer token",
+			target:         "/push/frontDoor",
+			header:         map[string]string{"Authorization": "Bearer secret", "Content-Type": "image/jpeg"},
+			body:           jpegData,
+			expectedStatus: http.StatusNoContent,
+			expectedDir:    "frontDoor",
+		},
+		{
+			name:           "query token and custom path",
+			target:         "/upload/garden?token=other",
+			body:           jpegData,
+			expectedStatus: http.StatusNoContent,
+			expectedDir:    "garden",
+		},
+		{
+			name:           "multipart",
+			target:         "/push/frontDoor?token=secret",
+			header:         map[string]string{"Content-Type": contentType},
+			body:           multipartData,
+			expectedStatus: http.StatusNoContent,
+			expectedDir:    "frontDoor",
+		},
+		{
+			name:           "token of other camera",
+			target:         "/push/frontDoor?token=other",
+			body:           jpegData,
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "wrong auth scheme",
+			target:         "/push/frontDoor?token=secret",
+			header:         map[string]string{"Authorization": "Basic c2VjcmV0"},
+			body:           jpegData,
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "get",
+			method:         http.MethodGet,
+			target:         "/push/frontDoor?token=secret",
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+		{
+			name:           "polled camera",
+			target:         "/push/polled",
+			body:           jpegData,
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "too large",
+			target:         "/push/frontDoor?token=secret",
+			body:           bytes.Repeat([]byte{0xff}, 2048),
+			expectedStatus: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:           "empty",
+			target:         "/push/frontDoor?token=secret",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "not an image",
+			target:         "/push/frontDoor?token=secret",
+			body:           []byte("<html>login</html>"),
+			expectedStatus: http.StatusUnprocessableEntity,
+			expectedDir:    "frontDoor/rejected",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			outdir := t.TempDir()
+			method := tt.method
+			if method == "" {
+				method = http.MethodPost
+			}
+			req := httptest.NewRequest(method, tt.target, bytes.NewReader(tt.body))
+			for k, v := range tt.header {
+				req.Header.Set(k, v)
+			}
+			rec := httptest.NewRecorder()
+
+			newTestHandler(t, outdir).ServeHTTP(rec, req)
+
+			if rec.Code != tt.expectedStatus {
+				t.Errorf("status = %d, want %d (%s)", rec.Code, tt.expectedStatus, rec.Body)
+			}
+			if tt.expectedDir == "" {
+				if entries, _ := os.ReadDir(outdir); len(entries) != 0 {
+					t.Errorf("output directory not empty: %v", entries)
+				}
+				return
+			}
+			entries, err := os.ReadDir(filepath.Join(outdir, tt.expectedDir))
+			if err != nil || len(entries) == 0 {
+				t.Fatalf("no file stored in %s: %v", tt.expectedDir, err)
+			}
+			name := entries[len(entries)-1].Name()
+			if tt.expectedStatus == http.StatusNoContent && !strings.HasSuffix(name, ".jpg") {
+				t.Errorf("stored file = %s, want .jpg", name)
+			}
+		})
+	}
+}
+
+func TestNewHandler_invalid(t *testing.T) {
+	tests := map[string][]config.CameraConfig{
+		"camera a: push camera requires push.token": {
+			{Name: "a", Type: CameraType},
+		},
+		"camera b: push path /push/cam already used by camera a": {
+			{Name: "a", Type: CameraType, Push: config.CameraPushConfig{Path: "/push/cam", Token: "x"}},
+			{Name: "b", Type: CameraType, Push: config.CameraPushConfig{Path: "push/cam", Token: "y"}},
+		},
+	}
+	for expectedError, cameras := range tests {
+		_, err := NewHandler(&config.Config{Cameras: cameras}, nil, nil, discardLogger)
+		if err == nil || err.Error() != expectedError {
+			t.Errorf("error = %v, want %v", err, expectedError)
+		}
+	}
+}
@@ -1,61 +1,272 @@
 package snapshot
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"io"
 	"log/slog"
-	"os"
+	"path"
 	"path/filepath"
+	"sync"
 	"time"
 
 	"github.com/stone/timelapser/internal/camera"
 	"github.com/stone/timelapser/internal/config"
+	"github.com/stone/timelapser/internal/dedup"
+	"github.com/stone/timelapser/internal/imageformat"
+	"github.com/stone/timelapser/internal/layout"
+	"github.com/stone/timelapser/internal/storage"
 	"github.com/stone/timelapser/internal/utils"
 )
 
-func TakeCameraSnapshot(camconfig *config.CameraConfig, outdir string, logger *slog.Logger) error {
-	if err := os.MkdirAll(outdir, 0o755); err != nil {
-		return fmt.Errorf("failed to create output directory: %v", err)
-	}
-	cam := camera.NewCamera(*camconfig)
-	name := utils.ToCamelCase(camconfig.Name)
+// rejectedDirName is the camera subdirectory that receives invalid snapshots.
+const rejectedDirName = "rejected"
+
+// lastFrames caches the fingerprint of the last stored frame per camera for
+// deduplication.
+var lastFrames sync.Map // frameKey → dedup.Fingerprint
+
+type frameKey struct {
+	store  storage.Storage
+	camera string
+}
+
+// dedupLookback limits how far back the last frame is searched after a
+// restart. An older frame is not worth comparing against.
+const dedupLookback = 24 * time.Hour
+
+// ErrRejected is returned when a frame fails validation.
+var ErrRejected = errors.New("snapshot rejected")
+
+func TakeCameraSnapshot(ctx context.Context, src camera.Source, camconfig *config.CameraConfig, store storage.Storage, logger *slog.Logger) error {
 	logger.Debug("Retrieving snapshot", "name", camconfig.Name)
 
-	cameraDir := filepath.Join(outdir, name)
-	if err := os.MkdirAll(cameraDir, 0o755); err != nil {
-		return fmt.Errorf("failed to create camera directory: %v", err)
+	// Download into the storage's file system, so storing is a rename.
+	fetchCtx := ctx
+	if adopter, ok := store.(storage.Adopter); ok {
+		if dir, err := adopter.TempDir(utils.ToCamelCase(camconfig.Name)); err == nil {
+			fetchCtx = camera.WithSpoolDir(ctx, dir)
+		}
+	}
+	frame, err := src.Fetch(fetchCtx)
+	if errors.Is(err, camera.ErrNotModified) {
+		logger.Info("Snapshot not modified, skipped", "name", camconfig.Name)
+		return nil
 	}
-
-	snapshot, err := cam.GetSnapshot()
 	if err != nil {
 		return fmt.Errorf("snapshot error for: %s error: %s", camconfig.Name, err)
 	}
+	defer frame.Close()
+
+	if err := StoreFrame(ctx, frame, camconfig, store, logger); err != nil {
+		return err
+	}
+	acknowledge(src, frame, camconfig, logger)
+
+	return nil
+}
+
+// StoreFrame validates frame and stores it below the camera, unless it is a
+// duplicate of the last stored frame. Invalid frames are moved aside to
+// rejected/ and reported as an error.
+func StoreFrame(ctx context.Context, frame *camera.Frame, camconfig *config.CameraConfig, store storage.Storage, logger *slog.Logger) error {
+	name := utils.ToCamelCase(camconfig.Name)
+
+	format, err := validate(frame, camconfig.Validation)
+	if err != nil {
+		return reject(ctx, store, name, frame, format, err, camconfig, logger)
+	}
+
+	r, size := frame.Reader()
+	var fingerprint dedup.Fingerprint
+	key := frameKey{store, name}
+	if camconfig.Dedup.Enabled {
+		if fingerprint, err = dedup.NewFingerprintAt(r, size, camconfig.Dedup.Perceptual); err != nil {
+			return fmt.Errorf("failed to read snapshot: %v", err)
+		}
+		if prev, ok := lastFingerprint(ctx, key, camconfig.Dedup.Perceptual); ok && dedup.IsDuplicate(prev, fingerprint, camconfig.Dedup) {
+			logger.Info("Snapshot unchanged, skipped", "name", camconfig.Name)
+			return nil
+		}
+	}
 
-	// Write atomically: temp file in the same directory → rename.
-	// This prevents the timelapse job from reading a partially-written file.
-	filename := filepath.Join(cameraDir, fmt.Sprintf("%d.png", time.Now().UnixNano()))
-	tmp := filename + ".tmp"
-	if err := os.WriteFile(tmp, snapshot, 0o644); err != nil {
+	l, err := layout.Parse(camconfig.Layout)
+	if err != nil {
+		return err
+	}
+	taken := frame.Time
+	if taken.IsZero() {
+		taken = time.Now()
+	}
+	filename := path.Join(name, filepath.ToSlash(l.Dir(taken)), fmt.Sprintf("%d%s", taken.UnixNano(), format.Extension))
+	if err := put(ctx, store, filename, frame); err != nil {
 		return fmt.Errorf("failed to write snapshot: %v", err)
 	}
-	if err := os.Rename(tmp, filename); err != nil {
-		os.Remove(tmp)
-		return fmt.Errorf("failed to rename snapshot: %v", err)
+	if camconfig.Dedup.Enabled {
+		lastFrames.Store(key, fingerprint)
 	}
 
-	logger.Info("Snapshot saved for", "name", camconfig.Name, "file", filename)
+	logger.Info("Snapshot saved for", "name", camconfig.Name, "file", filename, "format", format.Name)
 
 	return nil
 }
 
-func TakeSnapshot(config *config.Config) error {
+// acknowledge tells sources that implement camera.Acknowledger that the frame
+// was handled. Failures are only logged since the snapshot itself is stored.
+func acknowledge(src camera.Source, frame *camera.Frame, camconfig *config.CameraConfig, logger *slog.Logger) {
+	ack, ok := src.(camera.Acknowledger)
+	if !ok {
+		return
+	}
+	if err := ack.Ack(frame); err != nil {
+		logger.Warn("Snapshot acknowledge failed for", "name", camconfig.Name, "err", err)
+	}
+}
+
+// lastFingerprint returns the fingerprint of the last frame stored for the
+// camera. After a restart it is computed from the newest stored image.
+func lastFingerprint(ctx context.Context, key frameKey, perceptual bool) (dedup.Fingerprint, bool) {
+	if fp, ok := lastFrames.Load(key); ok {
+		return fp.(dedup.Fingerprint), true
+	}
+
+	objects, err := key.store.List(ctx, key.camera, time.Now().Add(-dedupLookback), time.Time{})
+	if err != nil || len(objects) == 0 {
+		return dedup.Fingerprint{}, false
+	}
+	r, err := key.store.Get(ctx, objects[len(objects)-1].Key)
+	if err != nil {
+		return dedup.Fingerprint{}, false
+	}
+	data, err := io.ReadAll(r)
+	r.Close()
+	if err != nil {
+		return dedup.Fingerprint{}, false
+	}
+	fp := dedup.NewFingerprint(data, perceptual)
+	lastFrames.Store(key, fp)
+	return fp, true
+}
+
+// MigrateLayout moves the stored snapshots of a camera into the partitions of
+// its configured layout, e.g. from a flat directory to YYYY/MM/DD. Object
+// storages are listed by snapshot time whatever the layout, so only local
+// directories are migrated.
+func MigrateLayout(camconfig *config.CameraConfig, store storage.Storage, logger *slog.Logger) error {
+	l, err := layout.Parse(camconfig.Layout)
+	if err != nil {
+		return err
+	}
+	local, ok := store.(*storage.Local)
+	if !ok {
+		return nil
+	}
+	moved, err := l.Migrate(local.Path(utils.ToCamelCase(camconfig.Name)))
+	if moved > 0 {
+		logger.Info("Snapshots moved to layout", "name", camconfig.Name, "layout", camconfig.Layout, "snapshots", moved)
+	}
+	return err
+}
+
+// validate detects the image format and checks the frame against the
+// camera's validation settings. The detected format is returned even when a
+// later check fails, so rejected frames keep a meaningful extension.
+func validate(frame *camera.Frame, cfg config.ValidationConfig) (imageformat.Format, error) {
+	r, size := frame.Reader()
+	if size < cfg.MinSize {
+		return imageformat.Format{}, fmt.Errorf("%d bytes is below the minimum of %d bytes", size, cfg.MinSize)
+	}
+
+	format, err := imageformat.DetectAt(r, size, frame.ContentType)
+	if err != nil {
+		return imageformat.Format{}, err
+	}
+
+	img, err := imageformat.DecodeAt(r, size, format, cfg.Decode)
+	if err != nil {
+		return format, err
+	}
+	if img.Width < cfg.MinWidth || img.Height < cfg.MinHeight {
+		return format, fmt.Errorf("%dx%d is below the minimum of %dx%d", img.Width, img.Height, cfg.MinWidth, cfg.MinHeight)
+	}
+
+	return format, nil
+}
+
+// put stores frame under key. A frame downloaded to a temporary file is
+// renamed into storages that can adopt it instead of being copied.
+func put(ctx context.Context, store storage.Storage, key string, frame *camera.Frame) error {
+	if adopter, ok := store.(storage.Adopter); ok {
+		if file, ok := frame.File(); ok {
+			return adopter.Adopt(ctx, key, file)
+		}
+	}
+	r, size := frame.Reader()
+	return store.Put(ctx, key, io.NewSectionReader(r, 0, size), size)
+}
+
+// reject stores an invalid frame in the rejected/ subdirectory of the camera
+// for later inspection, out of reach of timelapse generation.
+func reject(ctx context.Context, store storage.Storage, name string, frame *camera.Frame, format imageformat.Format, reason error, camconfig *config.CameraConfig, logger *slog.Logger) error {
+	ext := format.Extension
+	if ext == "" {
+		ext = ".bin"
+	}
+	filename := path.Join(name, rejectedDirName, fmt.Sprintf("%d%s", time.Now().UnixNano(), ext))
+	_, size := frame.Reader()
+	if err := put(ctx, store, filename, frame); err != nil {
+		return fmt.Errorf("failed to write rejected snapshot: %v", err)
+	}
+
+	logger.Warn("Snapshot rejected for", "name", camconfig.Name, "reason", reason, "file", filename, "bytes", size)
+
+	return fmt.Errorf("%w for: %s error: %v", ErrRejected, camconfig.Name, reason)
+}
+
+// TakeSnapshot captures all cameras once, up to config.Concurrency at the same
+// time, so snapshots of different cameras are taken at nearly the same
+// instant. Cameras sharing a host are further limited by camera.SetHostLimit.
+// Cancelling ctx aborts the captures in flight.
+func TakeSnapshot(ctx context.Context, config *config.Config) error {
 	logger := config.Logger
-	var errs []error
-	for _, camConfig := range config.Cameras {
-		if err := TakeCameraSnapshot(&camConfig, config.OutputDir, logger); err != nil {
-			logger.Error("Snapshot error for", "name", camConfig.Name, "err", err, "continue", true)
-			errs = append(errs, fmt.Errorf("%s: %w", camConfig.Name, err))
+	store, err := storage.New(config)
+	if err != nil {
+		return err
+	}
+	concurrency := config.Concurrency
+	if concurrency <= 0 {
+		concurrency = len(config.Cameras)
+	}
+
+	// One slot per camera keeps the joined errors in configuration order.
+	errs := make([]error, len(config.Cameras))
+	sem := make(chan struct{}, max(concurrency, 1))
+	var wg sync.WaitGroup
+	for i, camConfig := range config.Cameras {
+		// Push and ftp cameras upload their own snapshots.
+		if camConfig.Type == "push" || camConfig.Type == "ftp" {
+			continue
 		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if err := takeSnapshot(ctx, &camConfig, store, logger); err != nil {
+				logger.Error("Snapshot error for", "name", camConfig.Name, "err", err, "continue", true)
+				errs[i] = fmt.Errorf("%s: %w", camConfig.Name, err)
+			}
+		}()
 	}
+	wg.Wait()
 	return errors.Join(errs...)
 }
+
+func takeSnapshot(ctx context.Context, camConfig *config.CameraConfig, store storage.Storage, logger *slog.Logger) error {
+	src, err := camera.NewSource(*camConfig)
+	if err != nil {
+		return err
+	}
+	return TakeCameraSnapshot(ctx, src, camConfig, store, logger)
+}
@@ -1,11 +1,586 @@
 package snapshot
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/stone/timelapser/internal/camera"
+	"github.com/stone/timelapser/internal/config"
+	"github.com/stone/timelapser/internal/storage"
 	"github.com/stone/timelapser/internal/utils"
 )
 
+// fakeSource implements camera.Source interface
+type fakeSource struct {
+	frame *camera.Frame
+	err   error
+}
+
+func (f *fakeSource) Fetch(context.Context) (*camera.Frame, error) {
+	return f.frame, f.err
+}
+
+var discardLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+// encodeJPEG returns a valid JPEG image of the given size.
+func encodeJPEG(t *testing.T, width, height int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, width, height)), nil); err != nil {
+		t.Fatalf("encoding jpeg: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// webpData is a lossless 2x2 WebP header, enough for the structural checks.
+var webpData = []byte("RIFF\x19\x00\x00\x00WEBPVP8L\x0d\x00\x00\x00\x2f\x01\x40\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00")
+
+// listFiles returns the names of the regular files in dir.
+func listFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatalf("reading %s: %v", dir, err)
+	}
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			names = append(names, entry.Name())
+		}
+	}
+	return names
+}
+
+func TestTakeCameraSnapshot(t *testing.T) {
+	jpegData := encodeJPEG(t, 64, 48)
+
+	tests := []struct {
+		name             string
+		source           *fakeSource
+		validation       config.ValidationConfig
+		expectedData     []byte
+		expectedExt      string
+		expectedError    string
+		expectedRejected string // extension of the quarantined file
+	}{
+		{
+			name:         "frame is written",
+			source:       &fakeSource{frame: &camera.Frame{Data: jpegData}},
+			validation:   config.ValidationConfig{Decode: true, MinWidth: 64, MinHeight: 48},
+			expectedData: jpegData,
+			expectedExt:  ".jpg",
+		},
+		{
+			name:         "webp keeps its extension",
+			source:       &fakeSource{frame: &camera.Frame{Data: webpData, ContentType: "image/jpeg"}},
+			expectedData: webpData,
+			expectedExt:  ".webp",
+		},
+		{
+			name: "html error page is rejected",
+			source: &fakeSource{frame: &camera.Frame{
+				Data:        []byte("<html><body>Camera offline</body></html>"),
+				ContentType: "text/html",
+			}},
+			expectedError:    "not a supported image",
+			expectedRejected: ".bin",
+		},
+		{
+			name:             "jpeg cut inside the header is rejected",
+			source:           &fakeSource{frame: &camera.Frame{Data: jpegData[:20]}},
+			expectedError:    "truncated",
+			expectedRejected: ".jpg",
+		},
+		{
+			name:             "zero-byte body is rejected",
+			source:           &fakeSource{frame: &camera.Frame{Data: []byte{}}},
+			expectedError:    "empty response",
+			expectedRejected: ".bin",
+		},
+		{
+			name:             "truncated jpeg is rejected",
+			source:           &fakeSource{frame: &camera.Frame{Data: jpegData[:len(jpegData)-10]}},
+			expectedError:    "truncated",
+			expectedRejected: ".jpg",
+		},
+		{
+			name:             "too small image is rejected",
+			source:           &fakeSource{frame: &camera.Frame{Data: jpegData}},
+			validation:       config.ValidationConfig{MinWidth: 640, MinHeight: 480},
+			expectedError:    "64x48 is below the minimum of 640x480",
+			expectedRejected: ".jpg",
+		},
+		{
+			name:             "too few bytes is rejected",
+			source:           &fakeSource{frame: &camera.Frame{Data: jpegData}},
+			validation:       config.ValidationConfig{MinSize: 1 << 20},
+			expectedError:    "below the minimum",
+			expectedRejected: ".bin",
+		},
+		{
+			name:          "source error",
+			source:        &fakeSource{err: errors.New("camera offline")},
+			expectedError: "camera offline",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			outdir := t.TempDir()
+			camConfig := &config.CameraConfig{Name: "Front Door", Validation: tt.validation}
+			cameraDir := filepath.Join(outdir, "frontDoor")
+
+			err := TakeCameraSnapshot(context.Background(), tt.source, camConfig, storage.NewLocal(outdir), discardLogger)
+
+			files := listFiles(t, cameraDir)
+			rejected := listFiles(t, filepath.Join(cameraDir, rejectedDirName))
+
+			if tt.expectedError != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.expectedError) {
+					t.Errorf("error = %v, want %v", err, tt.expectedError)
+				}
+				if len(files) != 0 {
+					t.Errorf("expected no snapshots, got %v", files)
+				}
+				if tt.expectedRejected == "" {
+					if len(rejected) != 0 {
+						t.Errorf("expected no rejected files, got %v", rejected)
+					}
+					return
+				}
+				if len(rejected) != 1 || filepath.Ext(rejected[0]) != tt.expectedRejected {
+					t.Errorf("rejected = %v, want one %s file", rejected, tt.expectedRejected)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(files) != 1 {
+				t.Fatalf("expected 1 file, got %d", len(files))
+			}
+			data, err := os.ReadFile(filepath.Join(cameraDir, files[0]))
+			if err != nil {
+				t.Fatalf("reading snapshot: %v", err)
+			}
+			if !bytes.Equal(data, tt.expectedData) {
+				t.Errorf("data = %v, want %v", data, tt.expectedData)
+			}
+			if ext := filepath.Ext(files[0]); ext != tt.expectedExt {
+				t.Errorf("extension = %s, want %s", ext, tt.expectedExt)
+			}
+		})
+	}
+}
+
+func TestTakeCameraSnapshot_notModified(t *testing.T) {
+	outdir := t.TempDir()
+	src := &fakeSource{err: camera.ErrNotModified}
+
+	err := TakeCameraSnapshot(context.Background(), src, &config.CameraConfig{Name: "Remote"}, storage.NewLocal(outdir), discardLogger)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if files := listFiles(t, filepath.Join(outdir, "remote")); len(files) != 0 {
+		t.Errorf("files = %v, want none", files)
+	}
+}
+
+func TestTakeCameraSnapshot_conditionalAfterReject(t *testing.T) {
+	outdir := t.TempDir()
+	jpegData := encodeJPEG(t, 64, 48)
+
+	// The first response is cut off, later ones are complete. All carry the
+	// same ETag, and a matching If-None-Match is answered with 304.
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		if requests.Add(1) == 1 {
+			w.Write(jpegData[:len(jpegData)/2])
+			return
+		}
+		w.Write(jpegData)
+	}))
+	defer server.Close()
+
+	camConfig := &config.CameraConfig{Name: "Remote", SnapshotURL: server.URL}
+	src, err := camera.NewSource(*camConfig)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	store := storage.NewLocal(outdir)
+
+	if err := TakeCameraSnapshot(context.Background(), src, camConfig, store, discardLogger); !errors.Is(err, ErrRejected) {
+		t.Fatalf("truncated frame: error = %v, want ErrRejected", err)
+	}
+	// The rejected frame must not make the next request conditional.
+	if err := TakeCameraSnapshot(context.Background(), src, camConfig, store, discardLogger); err != nil {
+		t.Fatalf("after reject: unexpected error: %v", err)
+	}
+	if files := listFiles(t, filepath.Join(outdir, "remote")); len(files) != 1 {
+		t.Fatalf("after reject: files = %v, want 1", files)
+	}
+	// The stored frame does.
+	if err := TakeCameraSnapshot(context.Background(), src, camConfig, store, discardLogger); err != nil {
+		t.Fatalf("unchanged: unexpected error: %v", err)
+	}
+	if got := requests.Load(); got != 2 {
+		t.Errorf("full responses = %d, want 2", got)
+	}
+}
+
+func TestTakeCameraSnapshot_adoptsDownload(t *testing.T) {
+	jpegData := encodeJPEG(t, 64, 48)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(jpegData)
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name  string
+		store func(root string) storage.Storage
+	}{
+		{name: "rename", store: func(root string) storage.Storage { return storage.NewLocal(root) }},
+		// Embedding hides Adopt, as for S3.
+		{name: "copy", store: func(root string) storage.Storage { return struct{ storage.Storage }{storage.NewLocal(root)} }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmp := t.TempDir()
+			t.Setenv("TMPDIR", tmp)
+			outdir := t.TempDir()
+			camConfig := &config.CameraConfig{Name: "Remote", SnapshotURL: server.URL}
+			src, err := camera.NewSource(*camConfig)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if err := TakeCameraSnapshot(context.Background(), src, camConfig, tt.store(outdir), discardLogger); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			files := listFiles(t, filepath.Join(outdir, "remote"))
+			if len(files) != 1 {
+				t.Fatalf("files = %v, want 1", files)
+			}
+			info, err := os.Stat(filepath.Join(outdir, "remote", files[0]))
+			if err != nil || info.Size() != int64(len(jpegData)) || info.Mode().Perm() != 0o644 {
+				t.Errorf("stored file = %v, %v", info, err)
+			}
+			if entries, _ := os.ReadDir(tmp); len(entries) != 0 {
+				t.Errorf("temp files left behind: %v", entries)
+			}
+		})
+	}
+}
+
+func TestTakeCameraSnapshot_dedup(t *testing.T) {
+	outdir := t.TempDir()
+	store := storage.NewLocal(outdir)
+	cameraDir := filepath.Join(outdir, "riksgransen")
+	camConfig := &config.CameraConfig{
+		Name:  "Riksgransen",
+		Dedup: config.DedupConfig{Enabled: true},
+	}
+	first := encodeJPEG(t, 64, 48)
+	second := encodeJPEG(t, 80, 48)
+
+	take := func(data []byte) {
+		t.Helper()
+		src := &fakeSource{frame: &camera.Frame{Data: data}}
+		if err := TakeCameraSnapshot(context.Background(), src, camConfig, store, discardLogger); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	take(first)
+	take(first)
+	if files := listFiles(t, cameraDir); len(files) != 1 {
+		t.Fatalf("after duplicate: files = %v, want 1", files)
+	}
+
+	// After a restart the last frame is read back from disk.
+	lastFrames.Delete(frameKey{store, "riksgransen"})
+	take(bytes.Clone(first))
+	if files := listFiles(t, cameraDir); len(files) != 1 {
+		t.Fatalf("after restart: files = %v, want 1", files)
+	}
+
+	take(second)
+	if files := listFiles(t, cameraDir); len(files) != 2 {
+		t.Fatalf("after change: files = %v, want 2", files)
+	}
+}
+
+func TestTakeCameraSnapshot_layout(t *testing.T) {
+	outdir := t.TempDir()
+	store := storage.NewLocal(outdir)
+	cameraDir := filepath.Join(outdir, "riksgransen")
+	camConfig := &config.CameraConfig{
+		Name:   "Riksgransen",
+		Layout: "YYYY/MM/DD",
+		Dedup:  config.DedupConfig{Enabled: true},
+	}
+	frame := encodeJPEG(t, 64, 48)
+
+	take := func(data []byte) []string {
+		t.Helper()
+		src := &fakeSource{frame: &camera.Frame{Data: data}}
+		if err := TakeCameraSnapshot(context.Background(), src, camConfig, store, discardLogger); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		objects, err := store.List(context.Background(), "riksgransen", time.Time{}, time.Time{})
+		if err != nil {
+			t.Fatalf("listing images: %v", err)
+		}
+		var keys []string
+		for _, object := range objects {
+			keys = append(keys, object.Key)
+		}
+		return keys
+	}
+
+	keys := take(frame)
+	if len(keys) != 1 {
+		t.Fatalf("images = %v, want 1", keys)
+	}
+	var ns int64
+	fmt.Sscanf(path.Base(keys[0]), "%d", &ns)
+	if expected := "riksgransen/" + time.Unix(0, ns).Format("2006/01/02"); path.Dir(keys[0]) != expected {
+		t.Errorf("partition = %s, want %s", path.Dir(keys[0]), expected)
+	}
+	if files := listFiles(t, cameraDir); len(files) != 0 {
+		t.Errorf("camera directory files = %v, want none", files)
+	}
+
+	// After a restart the last frame is read back from its partition.
+	lastFrames.Delete(frameKey{store, "riksgransen"})
+	if keys := take(bytes.Clone(frame)); len(keys) != 1 {
+		t.Errorf("after restart: images = %v, want 1", keys)
+	}
+}
+
+func TestMigrateLayout(t *testing.T) {
+	outdir := t.TempDir()
+	cameraDir := filepath.Join(outdir, "riksgransen")
+	if err := os.MkdirAll(cameraDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	taken := time.Date(2024, 5, 31, 23, 59, 0, 0, time.Local)
+	name := fmt.Sprintf("%d.jpg", taken.UnixNano())
+	if err := os.WriteFile(filepath.Join(cameraDir, name), []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	camConfig := &config.CameraConfig{Name: "Riksgransen", Layout: "YYYY-MM/DD"}
+	if err := MigrateLayout(camConfig, storage.NewLocal(outdir), discardLogger); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(cameraDir, "2024-05", "31", name)); err != nil {
+		t.Errorf("snapshot not moved: %v", err)
+	}
+
+	camConfig.Layout = "bogus"
+	if err := MigrateLayout(camConfig, storage.NewLocal(outdir), discardLogger); err == nil {
+		t.Error("expected an error for an invalid layout")
+	}
+}
+
+func TestTakeCameraSnapshot_fileSource(t *testing.T) {
+	outdir := t.TempDir()
+	dropDir := t.TempDir()
+	camConfig := config.CameraConfig{
+		Name: "Share",
+		Type: "file",
+		File: config.FileConfig{Path: dropDir, After: "remove"},
+	}
+	src, err := camera.NewSource(camConfig)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	os.WriteFile(filepath.Join(dropDir, "broken.jpg"), []byte("not an image"), 0o644)
+	if err := TakeCameraSnapshot(context.Background(), src, &camConfig, storage.NewLocal(outdir), discardLogger); err == nil {
+		t.Fatal("expected rejection")
+	}
+	if files := listFiles(t, dropDir); len(files) != 1 {
+		t.Errorf("rejected source file was removed: files = %v", files)
+	}
+
+	os.Remove(filepath.Join(dropDir, "broken.jpg"))
+	os.WriteFile(filepath.Join(dropDir, "image.jpg"), encodeJPEG(t, 64, 48), 0o644)
+	if err := TakeCameraSnapshot(context.Background(), src, &camConfig, storage.NewLocal(outdir), discardLogger); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if files := listFiles(t, filepath.Join(outdir, "share")); len(files) != 1 || !strings.HasSuffix(files[0], ".jpg") {
+		t.Errorf("stored files = %v, want one .jpg", files)
+	}
+	if files := listFiles(t, dropDir); len(files) != 0 {
+		t.Errorf("source files = %v, want removed", files)
+	}
+}
+
+// failingStore fails every Put.
+type failingStore struct{ storage.Storage }
+
+func (failingStore) Put(context.Context, string, io.Reader, int64) error {
+	return errors.New("disk full")
+}
+
+func TestTakeCameraSnapshot_fileSourceRetry(t *testing.T) {
+	outdir := t.TempDir()
+	dropDir := t.TempDir()
+	camConfig := config.CameraConfig{Name: "Share", Type: "file", File: config.FileConfig{Path: dropDir}}
+	src, err := camera.NewSource(camConfig)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	modTime := time.Now().Add(-time.Minute)
+	image := filepath.Join(dropDir, "image.jpg")
+	os.WriteFile(image, encodeJPEG(t, 64, 48), 0o644)
+	os.Chtimes(image, modTime, modTime)
+
+	store := storage.NewLocal(outdir)
+	if err := TakeCameraSnapshot(context.Background(), src, &camConfig, failingStore{store}, discardLogger); err == nil {
+		t.Fatal("expected storage error")
+	}
+	// The file was not stored, so the next snapshot picks it up again.
+	if err := TakeCameraSnapshot(context.Background(), src, &camConfig, store, discardLogger); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := fmt.Sprintf("%d.jpg", modTime.UnixNano())
+	if files := listFiles(t, filepath.Join(outdir, "share")); len(files) != 1 || files[0] != expected {
+		t.Errorf("stored files = %v, want [%s]", files, expected)
+	}
+}
+
+func TestTakeSnapshot_concurrent(t *testing.T) {
+	jpegData := encodeJPEG(t, 64, 48)
+
+	tests := []struct {
+		name            string
+		hostLimit       config.HostLimitConfig
+		expectedMaxBusy int32
+	}{
+		{name: "all cameras at once", expectedMaxBusy: 3},
+		{name: "one request per host", hostLimit: config.HostLimitConfig{Concurrency: 1}, expectedMaxBusy: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var busy, maxBusy atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := busy.Add(1)
+				defer busy.Add(-1)
+				for m := maxBusy.Load(); n > m && !maxBusy.CompareAndSwap(m, n); m = maxBusy.Load() {
+				}
+				// Wait for the other cameras, unless they are held back.
+				deadline := time.Now().Add(200 * time.Millisecond)
+				for maxBusy.Load() < 3 && time.Now().Before(deadline) {
+					time.Sleep(5 * time.Millisecond)
+				}
+				w.Write(jpegData)
+			}))
+			defer server.Close()
+
+			camera.SetHostLimit(tt.hostLimit)
+			defer camera.SetHostLimit(config.HostLimitConfig{})
+
+			outdir := t.TempDir()
+			cfg := &config.Config{
+				OutputDir:   outdir,
+				Concurrency: 3,
+				Logger:      discardLogger,
+				Cameras: []config.CameraConfig{
+					{Name: "a", SnapshotURL: server.URL + "/a"},
+					{Name: "b", SnapshotURL: server.URL + "/b"},
+					{Name: "c", SnapshotURL: server.URL + "/c"},
+					{Name: "d", Type: "push"},
+				},
+			}
+
+			if err := TakeSnapshot(context.Background(), cfg); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := maxBusy.Load(); got != tt.expectedMaxBusy {
+				t.Errorf("max concurrent requests = %d, want %d", got, tt.expectedMaxBusy)
+			}
+			for _, name := range []string{"a", "b", "c"} {
+				if files := listFiles(t, filepath.Join(outdir, name)); len(files) != 1 {
+					t.Errorf("camera %s: files = %v, want 1", name, files)
+				}
+			}
+		})
+	}
+}
+
+func TestTakeSnapshot_errors(t *testing.T) {
+	cfg := &config.Config{
+		OutputDir: t.TempDir(),
+		Logger:    discardLogger,
+		Cameras: []config.CameraConfig{
+			{Name: "first", Type: "unknown"},
+			{Name: "second", Type: "file", File: config.FileConfig{Path: filepath.Join(t.TempDir(), "missing.jpg")}},
+		},
+	}
+
+	err := TakeSnapshot(context.Background(), cfg)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	lines := strings.Split(err.Error(), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "first: ") || !strings.HasPrefix(lines[1], "second: ") {
+		t.Errorf("error = %q, want one line per camera in configuration order", err)
+	}
+}
+
+func TestTakeSnapshot_cancel(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	cfg := &config.Config{
+		OutputDir: t.TempDir(),
+		Logger:    discardLogger,
+		Cameras:   []config.CameraConfig{{Name: "hung", SnapshotURL: server.URL}},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if err := TakeSnapshot(ctx, cfg); err == nil {
+		t.Fatal("expected error")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("TakeSnapshot returned after %v, want right after cancellation", elapsed)
+	}
+}
+
 func Test_toCamelCase(t *testing.T) {
 	tests := []struct {
 		name     string
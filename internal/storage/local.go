@@ -0,0 +1,146 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/stone/timelapser/internal/layout"
+)
+
+// Local stores objects as files below Root.
+type Local struct {
+	Root string
+}
+
+func NewLocal(root string) *Local {
+	return &Local{Root: root}
+}
+
+// Path returns the file of key, for tools such as ffmpeg that read files.
+func (s *Local) Path(key string) string {
+	return filepath.Join(s.Root, filepath.FromSlash(key))
+}
+
+// Put writes atomically: temp file in the same directory → rename. This
+// prevents the timelapse job from reading a partially-written file.
+func (s *Local) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	filename := s.Path(key)
+	if err := os.MkdirAll(filepath.Dir(filename), 0o755); err != nil {
+		return fmt.Errorf("failed to create directory: %v", err)
+	}
+
+	tmp := filename + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %v", key, err)
+	}
+	_, err = io.Copy(f, r)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write %s: %v", key, err)
+	}
+	if err := os.Rename(tmp, filename); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to rename %s: %v", key, err)
+	}
+	return nil
+}
+
+// TempDir returns the directory of prefix, so that adopted files are renamed
+// within one file system.
+func (s *Local) TempDir(prefix string) (string, error) {
+	dir := s.Path(prefix)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("failed to create directory: %v", err)
+	}
+	return dir, nil
+}
+
+// Adopt renames the complete file at path to key, which is as atomic as Put.
+func (s *Local) Adopt(ctx context.Context, key, path string) error {
+	filename := s.Path(key)
+	if err := os.MkdirAll(filepath.Dir(filename), 0o755); err != nil {
+		return fmt.Errorf("failed to create directory: %v", err)
+	}
+	// Temporary files are created private, stored files are not.
+	if err := os.Chmod(path, 0o644); err != nil {
+		return fmt.Errorf("failed to write %s: %v", key, err)
+	}
+	if err := os.Rename(path, filename); err != nil {
+		return fmt.Errorf("failed to rename %s: %v", key, err)
+	}
+	return nil
+}
+
+func (s *Local) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	f, err := os.Open(s.Path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	return f, err
+}
+
+// List walks the partitions below prefix in order, skipping those that
+// cannot hold snapshots of the requested range.
+func (s *Local) List(ctx context.Context, prefix string, from, to time.Time) ([]Object, error) {
+	prefix = dirPrefix(prefix)
+	var objects []Object
+	err := s.walk(ctx, prefix, "", from, to, &objects)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	sortObjects(objects)
+	return objects, nil
+}
+
+func (s *Local) walk(ctx context.Context, prefix, rel string, from, to time.Time, objects *[]Object) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(s.Path(prefix + rel))
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() {
+			sub := path.Join(rel, name)
+			if layout.IsPartition(name) && layout.Overlaps(sub, from, to) {
+				if err := s.walk(ctx, prefix, sub, from, to, objects); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if object, ok := snapshot(prefix, prefix+path.Join(rel, name), from, to); ok {
+			*objects = append(*objects, object)
+		}
+	}
+	return nil
+}
+
+// Delete removes key and the partitions it leaves empty.
+func (s *Local) Delete(ctx context.Context, key string) error {
+	if err := os.Remove(s.Path(key)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	for dir := path.Dir(key); dir != "." && layout.IsPartition(path.Base(dir)); dir = path.Dir(dir) {
+		// Fails for directories that still hold files.
+		if os.Remove(s.Path(dir)) != nil {
+			break
+		}
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+// Package storage stores snapshots and timelapses on the local file system or
+// in an S3-compatible object storage.
+//
+// Keys are slash-separated paths such as "riksgransen/2024/05/31/<unixnano>.jpg".
+// Snapshots are keys below a camera whose name is the Unix nanosecond
+// timestamp of the snapshot and whose directories, if any, are date
+// partitions (see package layout).
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/stone/timelapser/internal/config"
+	"github.com/stone/timelapser/internal/layout"
+)
+
+// ErrNotFound is returned by Get for a key that does not exist.
+var ErrNotFound = errors.New("object not found")
+
+// Object is a snapshot returned by List.
+type Object struct {
+	Key  string
+	Time time.Time // when the snapshot was taken
+}
+
+// Storage stores objects by key.
+type Storage interface {
+	// Put stores size bytes read from r under key, replacing an existing
+	// object. Readers never see a partially written object.
+	Put(ctx context.Context, key string, r io.Reader, size int64) error
+	// Get returns the content of key. The caller must close it.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// List returns the snapshots below prefix, e.g. "riksgransen/", taken in
+	// [from, to), in chronological order. A zero from or to leaves that end
+	// open.
+	List(ctx context.Context, prefix string, from, to time.Time) ([]Object, error)
+	// Delete removes key. Deleting a key that does not exist is not an error.
+	Delete(ctx context.Context, key string) error
+}
+
+// Adopter is implemented by storages that can take over a local file instead
+// of copying it with Put.
+type Adopter interface {
+	// TempDir returns a directory for temporary files that are later adopted
+	// under keys below prefix, creating it if needed.
+	TempDir(prefix string) (string, error)
+	// Adopt moves the file at path, which is in TempDir, to key, replacing an
+	// existing object.
+	Adopt(ctx context.Context, key, path string) error
+}
+
+// New returns the storage configured in cfg.
+func New(cfg *config.Config) (Storage, error) {
+	switch cfg.Storage.Type {
+	case "", "local":
+		return NewLocal(cfg.OutputDir), nil
+	case "s3":
+		return NewS3(cfg.Storage.S3)
+	default:
+		return nil, fmt.Errorf("unsupported storage type %q (local or s3)", cfg.Storage.Type)
+	}
+}
+
+// snapshot returns the snapshot stored under key, which is relative to the
+// listed prefix, and whether key is a snapshot at all.
+func snapshot(prefix, key string, from, to time.Time) (Object, bool) {
+	dir, name := path.Split(strings.TrimPrefix(key, prefix))
+	t, ok := layout.FrameTime(name)
+	if !ok || !inRange(t, from, to) {
+		return Object{}, false
+	}
+	if dir = strings.TrimSuffix(dir, "/"); dir != "" {
+		for _, partition := range strings.Split(dir, "/") {
+			if !layout.IsPartition(partition) {
+				return Object{}, false
+			}
+		}
+	}
+	return Object{Key: key, Time: t}, true
+}
+
+func inRange(t, from, to time.Time) bool {
+	return (from.IsZero() || !t.Before(from)) && (to.IsZero() || t.Before(to))
+}
+
+// dirPrefix returns prefix as a directory, ending with "/".
+func dirPrefix(prefix string) string {
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return prefix
+}
+
+// sortObjects orders the snapshots of a walk by time. The walk visits
+// partitions in name order, but snapshots stored before a layout change sit
+// in partitions of another layout, or in the camera directory itself, and
+// come out of that order.
+func sortObjects(objects []Object) {
+	sort.SliceStable(objects, func(i, j int) bool { return objects[i].Time.Before(objects[j].Time) })
+}
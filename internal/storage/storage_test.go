@@ -0,0 +1,178 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stone/timelapser/internal/config"
+)
+
+func frameKey(dir string, t time.Time) string {
+	return fmt.Sprintf("%s%d.jpg", dir, t.UnixNano())
+}
+
+func TestStorage(t *testing.T) {
+	day := time.Date(2024, 5, 31, 12, 0, 0, 0, time.Local)
+
+	storages := map[string]func(t *testing.T) Storage{
+		"local": func(t *testing.T) Storage { return NewLocal(t.TempDir()) },
+		"s3": func(t *testing.T) Storage {
+			s, _ := newFakeS3(t, "")
+			return s
+		},
+		"s3 with prefix": func(t *testing.T) Storage {
+			s, _ := newFakeS3(t, "site1/")
+			return s
+		},
+	}
+
+	for name, newStorage := range storages {
+		t.Run(name, func(t *testing.T) {
+			store := newStorage(t)
+			ctx := context.Background()
+
+			// Frames of a flat directory, of two layouts and of another camera,
+			// plus objects that are not snapshots.
+			frames := []string{
+				frameKey("frontDoor/", day.Add(-48*time.Hour)),
+				frameKey("frontDoor/2024/05/30/", day.Add(-24*time.Hour)),
+				frameKey("frontDoor/2024-05-31/", day),
+				frameKey("frontDoor/2024/06/01/", day.Add(24*time.Hour)),
+			}
+			others := []string{
+				frameKey("frontDoor/rejected/", day),
+				frameKey("frontDoorBack/", day),
+				"frontDoor/cover.jpg",
+				"frontDoor-20240531-120000.mp4",
+			}
+			for _, key := range append(append([]string{}, frames...), others...) {
+				if err := store.Put(ctx, key, strings.NewReader(key), int64(len(key))); err != nil {
+					t.Fatalf("Put(%s) error = %v", key, err)
+				}
+			}
+			if err := store.Put(ctx, "empty.txt", strings.NewReader(""), 0); err != nil {
+				t.Fatalf("Put() of an empty object error = %v", err)
+			}
+
+			tests := []struct {
+				name     string
+				from, to time.Time
+				expected []string
+			}{
+				{name: "all", expected: frames},
+				{name: "from", from: day, expected: frames[2:]},
+				{name: "to", to: day, expected: frames[:2]},
+				{name: "range", from: day.Add(-24 * time.Hour), to: day.Add(time.Nanosecond), expected: frames[1:3]},
+				{name: "none", from: day.Add(time.Minute), to: day.Add(time.Hour)},
+			}
+			for _, tt := range tests {
+				objects, err := store.List(ctx, "frontDoor", tt.from, tt.to)
+				if err != nil {
+					t.Fatalf("%s: List() error = %v", tt.name, err)
+				}
+				var keys []string
+				for _, object := range objects {
+					keys = append(keys, object.Key)
+				}
+				if !reflect.DeepEqual(keys, tt.expected) {
+					t.Errorf("%s: List() = %v, want %v", tt.name, keys, tt.expected)
+				}
+			}
+			if objects, err := store.List(ctx, "missing/", time.Time{}, time.Time{}); err != nil || len(objects) != 0 {
+				t.Errorf("List() of a missing camera = %v, %v", objects, err)
+			}
+
+			r, err := store.Get(ctx, frames[2])
+			if err != nil {
+				t.Fatalf("Get() error = %v", err)
+			}
+			data, err := io.ReadAll(r)
+			r.Close()
+			if err != nil || string(data) != frames[2] {
+				t.Errorf("Get() = %q, %v, want %q", data, err, frames[2])
+			}
+			if _, err := store.Get(ctx, "frontDoor/missing.jpg"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Get() of a missing key error = %v, want ErrNotFound", err)
+			}
+
+			for _, key := range frames {
+				if err := store.Delete(ctx, key); err != nil {
+					t.Fatalf("Delete(%s) error = %v", key, err)
+				}
+			}
+			if err := store.Delete(ctx, frames[0]); err != nil {
+				t.Errorf("Delete() of a missing key error = %v", err)
+			}
+			if objects, err := store.List(ctx, "frontDoor/", time.Time{}, time.Time{}); err != nil || len(objects) != 0 {
+				t.Errorf("List() after Delete() = %v, %v", objects, err)
+			}
+			if _, err := store.Get(ctx, others[0]); err != nil {
+				t.Errorf("Get() of a rejected frame error = %v", err)
+			}
+		})
+	}
+}
+
+func TestLocal(t *testing.T) {
+	root := t.TempDir()
+	store := NewLocal(root)
+	ctx := context.Background()
+	key := frameKey("frontDoor/2024/05/31/", time.Date(2024, 5, 31, 12, 0, 0, 0, time.Local))
+
+	if err := store.Put(ctx, key, strings.NewReader("frame"), 5); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(root, "frontDoor", "2024", "05", "31", filepath.Base(key)))
+	if err != nil || string(data) != "frame" {
+		t.Errorf("file = %q, %v", data, err)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(root, "frontDoor", "2024", "05", "31", "*.tmp")); len(matches) != 0 {
+		t.Errorf("temp files left behind: %v", matches)
+	}
+
+	// Deleting the last frame removes the emptied partitions, not the camera.
+	if err := store.Delete(ctx, key); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	entries, err := os.ReadDir(filepath.Join(root, "frontDoor"))
+	if err != nil || len(entries) != 0 {
+		t.Errorf("camera directory = %v, %v, want empty", entries, err)
+	}
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		storage       config.StorageConfig
+		expectedType  string
+		expectedError string
+	}{
+		{expectedType: "*storage.Local"},
+		{storage: config.StorageConfig{Type: "local"}, expectedType: "*storage.Local"},
+		{storage: config.StorageConfig{Type: "s3", S3: config.S3Config{Bucket: "b", AccessKey: "a", SecretKey: "s"}}, expectedType: "*storage.S3"},
+		{storage: config.StorageConfig{Type: "s3"}, expectedError: "s3.bucket"},
+		{storage: config.StorageConfig{Type: "ftp"}, expectedError: "unsupported storage type"},
+	}
+	for _, tt := range tests {
+		store, err := New(&config.Config{OutputDir: t.TempDir(), Storage: tt.storage})
+		if tt.expectedError != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.expectedError) {
+				t.Errorf("New(%+v) error = %v, want %q", tt.storage, err, tt.expectedError)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("New(%+v) error = %v", tt.storage, err)
+		}
+		if got := fmt.Sprintf("%T", store); got != tt.expectedType {
+			t.Errorf("New(%+v) = %s, want %s", tt.storage, got, tt.expectedType)
+		}
+	}
+}
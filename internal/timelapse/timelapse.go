@@ -2,8 +2,10 @@ package timelapse
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"os"
 	"os/exec"
@@ -13,6 +15,7 @@ import (
 	"time"
 
 	"github.com/stone/timelapser/internal/config"
+	"github.com/stone/timelapser/internal/storage"
 	"github.com/stone/timelapser/internal/utils"
 )
 
@@ -40,33 +43,36 @@ func buildFFmpegCommand(cfg *config.CameraConfig, listPath, outputPath string) (
 }
 
 // CreateTimelapse generates a timelapse video from a sequence of images
-func CreateTimelapse(cfg *config.CameraConfig, outputDir string, logger *slog.Logger) error {
+func CreateTimelapse(cfg *config.CameraConfig, store storage.Storage, logger *slog.Logger) error {
 	if _, err := exec.LookPath("ffmpeg"); err != nil {
 		return fmt.Errorf("ffmpeg not found in PATH: %w", err)
 	}
 
+	ctx := context.Background()
 	name := utils.ToCamelCase(cfg.Name)
-	folderPath := filepath.Join(outputDir, name)
 
-	// Verify camera directory exists
-	if _, err := os.Stat(folderPath); os.IsNotExist(err) {
-		return fmt.Errorf("camera directory not found: %w", err)
-	}
-
-	imageFiles, err := collectImageFiles(folderPath)
+	frames, err := store.List(ctx, name, time.Time{}, time.Time{})
 	if err != nil {
 		return fmt.Errorf("collecting image files: %w", err)
 	}
 
-	if len(imageFiles) == 0 {
+	if len(frames) == 0 {
 		return ErrNoSnapshots
 	}
 
+	// ffmpeg reads local files directly, other storages are downloaded first.
+	workDir, cleanup, err := localFrames(ctx, store, frames)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
 	timestamp := time.Now().Format("20060102-150405")
-	listPath := filepath.Join(outputDir, fmt.Sprintf("%s-%s.txt", name, timestamp))
-	outputPath := filepath.Join(outputDir, fmt.Sprintf("%s-%s.mp4", name, timestamp))
+	listPath := filepath.Join(workDir, fmt.Sprintf("%s-%s.txt", name, timestamp))
+	videoKey := fmt.Sprintf("%s-%s.mp4", name, timestamp)
+	outputPath := filepath.Join(workDir, videoKey)
 
-	if err := writeFileList(listPath, name, imageFiles, cfg.FrameDuration); err != nil {
+	if err := writeFileList(listPath, frames, cfg.FrameDuration); err != nil {
 		return fmt.Errorf("writing file list: %w", err)
 	}
 	defer cleanupFile(listPath, logger)
@@ -75,17 +81,22 @@ func CreateTimelapse(cfg *config.CameraConfig, outputDir string, logger *slog.Lo
 	if err := executeFFmpeg(cfg, listPath, outputPath, logger); err != nil {
 		return err
 	}
+	if _, ok := store.(*storage.Local); !ok {
+		if err := upload(ctx, store, videoKey, outputPath); err != nil {
+			return err
+		}
+	}
 	elapsed := time.Since(t1)
 
 	logger.Info("timelapse created",
 		"camera", cfg.Name,
-		"output", outputPath,
-		"snapshots", len(imageFiles),
+		"output", videoKey,
+		"snapshots", len(frames),
 		"duration", elapsed,
 	)
 
 	if cfg.Delete {
-		if err := cleanupImages(folderPath, imageFiles); err != nil {
+		if err := cleanupImages(ctx, store, frames); err != nil {
 			logger.Info("failed to cleanup some images",
 				"camera", cfg.Name,
 				"error", err,
@@ -96,41 +107,79 @@ func CreateTimelapse(cfg *config.CameraConfig, outputDir string, logger *slog.Lo
 	return nil
 }
 
-// collectImageFiles returns image filenames from folderPath sorted by name.
-// os.ReadDir returns entries in lexicographic order; since snapshot filenames
-// are Unix nanosecond timestamps this equals chronological order.
-func collectImageFiles(folderPath string) ([]string, error) {
-	entries, err := os.ReadDir(folderPath)
-	if err != nil {
-		return nil, fmt.Errorf("reading directory: %w", err)
+// localFrames returns the directory holding the files of frames under their
+// keys, downloading them to a temporary directory unless the storage is
+// local. cleanup removes the downloads.
+func localFrames(ctx context.Context, store storage.Storage, frames []storage.Object) (dir string, cleanup func(), err error) {
+	if local, ok := store.(*storage.Local); ok {
+		return local.Root, func() {}, nil
 	}
 
-	var names []string
-	for _, entry := range entries {
-		if isImageFile(entry) {
-			names = append(names, entry.Name())
+	dir, err = os.MkdirTemp("", "timelapser-")
+	if err != nil {
+		return "", nil, fmt.Errorf("creating download directory: %w", err)
+	}
+	cleanup = func() { os.RemoveAll(dir) }
+	for _, frame := range frames {
+		if err := download(ctx, store, frame.Key, filepath.Join(dir, filepath.FromSlash(frame.Key))); err != nil {
+			cleanup()
+			return "", nil, err
 		}
 	}
-	return names, nil
+	return dir, cleanup, nil
 }
 
-func isImageFile(entry os.DirEntry) bool {
-	if entry.IsDir() {
-		return false
+func download(ctx context.Context, store storage.Storage, key, filename string) error {
+	r, err := store.Get(ctx, key)
+	if err != nil {
+		return fmt.Errorf("downloading snapshot: %w", err)
+	}
+	defer r.Close()
+
+	if err := os.MkdirAll(filepath.Dir(filename), 0o755); err != nil {
+		return fmt.Errorf("downloading snapshot: %w", err)
+	}
+	f, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("downloading snapshot: %w", err)
 	}
-	name := strings.ToLower(entry.Name())
-	return strings.HasSuffix(name, ".jpg") || strings.HasSuffix(name, ".png")
+	_, err = io.Copy(f, r)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("downloading snapshot %s: %w", key, err)
+	}
+	return nil
 }
 
-func writeFileList(listPath, name string, imageFiles []string, frameDuration float64) error {
+func upload(ctx context.Context, store storage.Storage, key, filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return fmt.Errorf("uploading timelapse: %w", err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("uploading timelapse: %w", err)
+	}
+	if err := store.Put(ctx, key, f, info.Size()); err != nil {
+		return fmt.Errorf("uploading timelapse: %w", err)
+	}
+	return nil
+}
+
+// writeFileList writes the ffmpeg concat list. Frame paths are relative to
+// the list, which is written next to the camera directories.
+func writeFileList(listPath string, frames []storage.Object, frameDuration float64) error {
 	var fileList strings.Builder
-	for _, file := range imageFiles {
-		fileList.WriteString(fmt.Sprintf("file '%s'\n", filepath.Join(name, file)))
+	for _, frame := range frames {
+		fileList.WriteString(fmt.Sprintf("file '%s'\n", filepath.FromSlash(frame.Key)))
 		fileList.WriteString(fmt.Sprintf("duration %f\n", frameDuration))
 	}
 
 	// Add last frame again to ensure visibility
-	lastFrame := filepath.Join(name, imageFiles[len(imageFiles)-1])
+	lastFrame := filepath.FromSlash(frames[len(frames)-1].Key)
 	fileList.WriteString(fmt.Sprintf("file '%s'\n", lastFrame))
 
 	return os.WriteFile(listPath, []byte(fileList.String()), 0o644)
@@ -170,10 +219,11 @@ func cleanupFile(path string, logger *slog.Logger) {
 	}
 }
 
-func cleanupImages(folderPath string, imageFiles []string) error {
+// cleanupImages removes the frames included in the timelapse.
+func cleanupImages(ctx context.Context, store storage.Storage, frames []storage.Object) error {
 	var errs []string
-	for _, file := range imageFiles {
-		if err := os.Remove(filepath.Join(folderPath, file)); err != nil {
+	for _, frame := range frames {
+		if err := store.Delete(ctx, frame.Key); err != nil {
 			errs = append(errs, err.Error())
 		}
 	}
@@ -185,11 +235,15 @@ func cleanupImages(folderPath string, imageFiles []string) error {
 }
 
 func CreateAllTimelapse(config *config.Config, logger *slog.Logger) error {
+	store, err := storage.New(config)
+	if err != nil {
+		return err
+	}
 	var errs []error
 	for _, camConfig := range config.Cameras {
 		// we do not want to delete the original images when manually creating timelapse.
 		camConfig.Delete = false
-		if err := CreateTimelapse(&camConfig, config.OutputDir, logger); err != nil {
+		if err := CreateTimelapse(&camConfig, store, logger); err != nil {
 			logger.Error("Error creating timelapse", "name", camConfig.Name, "error", err)
 			errs = append(errs, fmt.Errorf("%s: %w", camConfig.Name, err))
 		}
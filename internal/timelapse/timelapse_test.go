@@ -1,10 +1,18 @@
 package timelapse
 
 import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stone/timelapser/internal/config"
+	"github.com/stone/timelapser/internal/storage"
 )
 
 // Sample configuration for testing
@@ -40,3 +48,84 @@ func TestBuildFFmpegCommand(t *testing.T) {
 		})
 	}
 }
+
+// memStorage is a Storage that is not local, so frames are downloaded.
+type memStorage struct {
+	objects map[string]string
+}
+
+func (m *memStorage) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	data, err := io.ReadAll(r)
+	m.objects[key] = string(data)
+	return err
+}
+
+func (m *memStorage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	data, ok := m.objects[key]
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	return io.NopCloser(strings.NewReader(data)), nil
+}
+
+func (m *memStorage) List(ctx context.Context, prefix string, from, to time.Time) ([]storage.Object, error) {
+	return nil, nil
+}
+
+func (m *memStorage) Delete(ctx context.Context, key string) error {
+	delete(m.objects, key)
+	return nil
+}
+
+func TestLocalFrames(t *testing.T) {
+	ctx := context.Background()
+	store := &memStorage{objects: map[string]string{
+		"frontDoor/2024/05/31/1717149600000000000.jpg": "first",
+		"frontDoor/2024/05/31/1717153200000000000.jpg": "second",
+	}}
+	frames := []storage.Object{
+		{Key: "frontDoor/2024/05/31/1717149600000000000.jpg"},
+		{Key: "frontDoor/2024/05/31/1717153200000000000.jpg"},
+	}
+
+	dir, cleanup, err := localFrames(ctx, store, frames)
+	if err != nil {
+		t.Fatalf("localFrames() error = %v", err)
+	}
+	for _, frame := range frames {
+		data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(frame.Key)))
+		if err != nil || string(data) != store.objects[frame.Key] {
+			t.Errorf("%s = %q, %v", frame.Key, data, err)
+		}
+	}
+
+	listPath := filepath.Join(dir, "list.txt")
+	if err := writeFileList(listPath, frames, 0.5); err != nil {
+		t.Fatalf("writeFileList() error = %v", err)
+	}
+	list, _ := os.ReadFile(listPath)
+	first, second := filepath.FromSlash(frames[0].Key), filepath.FromSlash(frames[1].Key)
+	expected := "file '" + first + "'\nduration 0.500000\nfile '" + second + "'\nduration 0.500000\nfile '" + second + "'\n"
+	if string(list) != expected {
+		t.Errorf("file list = %q, want %q", list, expected)
+	}
+
+	cleanup()
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("download directory not removed: %v", err)
+	}
+
+	if err := cleanupImages(ctx, store, frames); err != nil || len(store.objects) != 0 {
+		t.Errorf("cleanupImages() = %v, objects left %v", err, store.objects)
+	}
+
+	// Local storages are read in place.
+	local := storage.NewLocal(t.TempDir())
+	if dir, _, err := localFrames(ctx, local, frames); err != nil || dir != local.Root {
+		t.Errorf("localFrames() = %q, %v, want %q", dir, err, local.Root)
+	}
+
+	if _, _, err := localFrames(ctx, store, frames); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("localFrames() of missing frames error = %v, want ErrNotFound", err)
+	}
+}
@@ -1,6 +1,10 @@
 package utils
 
 import (
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
 	"strings"
 
 	"golang.org/x/text/cases"
@@ -28,3 +32,49 @@ func ToCamelCase(s string) string {
 
 	return result.String()
 }
+
+var envRef = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// ExpandEnv replaces ${NAME} references in s with the value of the
+// environment variable NAME. Unlike os.ExpandEnv a bare $ is left alone, so
+// values such as passwords may contain dollar signs, and referencing an
+// unset variable is an error instead of silently expanding to "".
+func ExpandEnv(s string) (string, error) {
+	var missing []string
+	expanded := envRef.ReplaceAllStringFunc(s, func(ref string) string {
+		name := envRef.FindStringSubmatch(ref)[1]
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			missing = append(missing, name)
+		}
+		return value
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("environment variable not set: %s", strings.Join(missing, ", "))
+	}
+	return expanded, nil
+}
+
+// sensitiveParams are query parameters masked by RedactURL.
+var sensitiveParams = regexp.MustCompile(`(?i)(pass|pwd|secret|token|key|auth|sig)`)
+
+// RedactURL masks the password and secret-looking query parameters of a URL
+// so it can be logged. Values that do not parse as a URL are returned as is.
+func RedactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	query := u.Query()
+	masked := false
+	for name := range query {
+		if sensitiveParams.MatchString(name) {
+			query.Set(name, "xxxxx")
+			masked = true
+		}
+	}
+	if masked {
+		u.RawQuery = query.Encode()
+	}
+	return u.Redacted()
+}
@@ -2,19 +2,29 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log/slog"
+	"net/http"
 	"os"
+	"os/signal"
+	"slices"
 	"sync"
+	"syscall"
+	"time"
 
 	"github.com/lmittmann/tint"
 	"github.com/mattn/go-isatty"
 	"github.com/robfig/cron/v3"
 	"github.com/stone/timelapser/internal/camera"
 	"github.com/stone/timelapser/internal/config"
+	"github.com/stone/timelapser/internal/discovery"
+	"github.com/stone/timelapser/internal/push"
 	"github.com/stone/timelapser/internal/snapshot"
+	"github.com/stone/timelapser/internal/storage"
 	"github.com/stone/timelapser/internal/timelapse"
+	"github.com/stone/timelapser/internal/utils"
 )
 
 // Build information. Populated at build-time using -ldflags
@@ -33,6 +43,9 @@ func main() {
 	flagListCameras := flag.Bool("list", false, "List configured cameras")
 	flagGetConfig := flag.Bool("example-config", false, "Print example configuration to stdout")
 	flagGetVersion := flag.Bool("version", false, "Print version and exit")
+	flagDiscover := flag.Bool("discover", false, "Find cameras on the local network and print their configuration")
+	flagDiscoverScan := flag.String("discover-scan", "", "With -discover, also scan this network (e.g. 192.168.1.0/24) for snapshot URLs")
+	flagDiscoverTimeout := flag.Duration("discover-timeout", 3*time.Second, "How long to wait for WS-Discovery answers")
 	flag.Parse()
 
 	if *flagGetVersion {
@@ -69,6 +82,15 @@ func main() {
 			NoColor: !isatty.IsTerminal(os.Stderr.Fd()),
 		}),
 	)
+	slog.SetDefault(logger)
+
+	if *flagDiscover {
+		if err := discover(*flagDiscoverScan, *flagDiscoverTimeout); err != nil {
+			logger.Error("Error discovering cameras", "error", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
 
 	logger.Debug("Opening configuration file", "config", *flagConfigPath)
 	config, err := config.LoadConfig(*flagConfigPath, logger)
@@ -78,11 +100,25 @@ func main() {
 	}
 
 	if logger.Handler().Enabled(context.TODO(), slog.LevelDebug) {
+		// Never log credentials: URLs are redacted and auth settings left out.
 		for _, camConfig := range config.Cameras {
-			logger.Debug("Camera configuration", "camera", camConfig.Name, "url", camConfig.SnapshotURL, "delete", camConfig.Delete)
+			url := camConfig.SnapshotURL
+			switch camConfig.Type {
+			case "rtsp":
+				url = camConfig.RTSP.URL
+			case "onvif":
+				url = camConfig.ONVIF.Host
+			case "file":
+				url = camConfig.File.Path
+			}
+			logger.Debug("Camera configuration", "camera", camConfig.Name, "type", camConfig.Type,
+				"url", utils.RedactURL(url), "auth", camConfig.Auth.Type, "delete", camConfig.Delete)
 		}
 	}
 
+	// Applies to the requests of all cameras on the same host
+	camera.SetHostLimit(config.HostLimit)
+
 	if *flagListCameras {
 		camera.ListCameras(config)
 		os.Exit(0)
@@ -92,7 +128,11 @@ func main() {
 
 	// Take snapshot of cameras and quit
 	if *flagSnapshot {
-		if err := snapshot.TakeSnapshot(config); err != nil {
+		// Ctrl-C aborts a capture that hangs.
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		err := snapshot.TakeSnapshot(ctx, config)
+		stop()
+		if err != nil {
 			logger.Error("Error taking snapshot", "error", err)
 			os.Exit(1)
 		}
@@ -107,6 +147,10 @@ func main() {
 		os.Exit(0)
 	}
 
+	// Cancelled on shutdown so in-flight snapshot fetches return early.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	// Per-camera mutex prevents the snapshot writer and timelapse reader/deleter
 	// from running concurrently against the same camera directory.
 	camLocks := make(map[string]*sync.Mutex)
@@ -114,34 +158,168 @@ func main() {
 		camLocks[config.Cameras[i].Name] = &sync.Mutex{}
 	}
 
+	store, err := storage.New(config)
+	if err != nil {
+		logger.Error("Error configuring storage", "error", err)
+		os.Exit(1)
+	}
+
+	// Move snapshots stored before the layout was configured or changed
+	for _, camConfig := range config.Cameras {
+		if err := snapshot.MigrateLayout(&camConfig, store, logger); err != nil {
+			logger.Error("Error moving snapshots to layout", "name", camConfig.Name, "error", err)
+			os.Exit(1)
+		}
+	}
+
+	// Sources are created once per camera and reused by every scheduled
+	// snapshot, so they can keep state such as connections between runs.
+	sources := make(map[string]camera.Source)
+	for _, camConfig := range config.Cameras {
+		if push.IsUploadCamera(&camConfig) {
+			continue
+		}
+		src, err := camera.NewSource(camConfig)
+		if err != nil {
+			logger.Error("Error creating camera source", "name", camConfig.Name, "error", err)
+			os.Exit(1)
+		}
+		sources[camConfig.Name] = src
+	}
+
 	// Schedule snapshots
 	for _, camConfig := range config.Cameras {
 		interval := camConfig.Interval
 		timelapseInterval := camConfig.TimelapseInterval
 		mu := camLocks[camConfig.Name]
+		src := sources[camConfig.Name]
 
-		logger.Info("Scheduling camera snapshot", "name", camConfig.Name, "interval", interval)
-		crn.AddFunc(interval, func() {
-			mu.Lock()
-			defer mu.Unlock()
-			if err := snapshot.TakeCameraSnapshot(&camConfig, config.OutputDir, logger); err != nil {
-				logger.Error("Error taking snapshot", "name", camConfig.Name, "error", err)
-			}
-		})
+		if src != nil {
+			logger.Info("Scheduling camera snapshot", "name", camConfig.Name, "interval", interval)
+			crn.AddFunc(interval, func() {
+				mu.Lock()
+				defer mu.Unlock()
+				if err := snapshot.TakeCameraSnapshot(ctx, src, &camConfig, store, logger); err != nil {
+					logger.Error("Error taking snapshot", "name", camConfig.Name, "error", err)
+				}
+			})
+		}
 
 		logger.Info("Scheduling timelapse generation", "name", camConfig.Name, "timelapseInterval", timelapseInterval)
 		crn.AddFunc(timelapseInterval, func() {
 			mu.Lock()
 			defer mu.Unlock()
-			if err := timelapse.CreateTimelapse(&camConfig, config.OutputDir, logger); err != nil {
+			if err := timelapse.CreateTimelapse(&camConfig, store, logger); err != nil {
 				logger.Error("Error generating timelapse", "name", camConfig.Name, "error", err)
 			}
 		})
 	}
 
+	// Receive uploads from push and ftp cameras
+	for _, camConfig := range config.Cameras {
+		if camConfig.Type == push.CameraType && config.Push.Listen == "" {
+			logger.Error("Push cameras require push.listen", "name", camConfig.Name)
+			os.Exit(1)
+		}
+		if camConfig.Type == push.FTPCameraType && config.FTP.Listen == "" {
+			logger.Error("FTP cameras require ftp.listen", "name", camConfig.Name)
+			os.Exit(1)
+		}
+	}
+	lock := func(name string) *sync.Mutex { return camLocks[name] }
+
+	var server *http.Server
+	if config.Push.Listen != "" {
+		handler, err := push.NewHandler(config, store, lock, logger)
+		if err != nil {
+			logger.Error("Error configuring push cameras", "error", err)
+			os.Exit(1)
+		}
+		for _, camConfig := range config.Cameras {
+			if camConfig.Type == push.CameraType {
+				logger.Info("Accepting camera uploads", "name", camConfig.Name, "path", push.Path(&camConfig))
+			}
+		}
+		server = &http.Server{Addr: config.Push.Listen, Handler: handler, ReadHeaderTimeout: 10 * time.Second}
+		go func() {
+			if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				logger.Error("Error receiving uploads", "listen", config.Push.Listen, "error", err)
+				os.Exit(1)
+			}
+		}()
+	}
+
+	var ftpDone chan struct{}
+	if config.FTP.Listen != "" {
+		ftpServer, err := push.NewFTPServer(config, store, lock, logger)
+		if err != nil {
+			logger.Error("Error configuring ftp cameras", "error", err)
+			os.Exit(1)
+		}
+		for _, camConfig := range config.Cameras {
+			if camConfig.Type == push.FTPCameraType {
+				logger.Info("Accepting camera uploads", "name", camConfig.Name, "ftpUser", push.FTPUsername(&camConfig))
+			}
+		}
+		ftpDone = make(chan struct{})
+		go func() {
+			defer close(ftpDone)
+			if err := ftpServer.ListenAndServe(ctx); err != nil {
+				logger.Error("Error receiving ftp uploads", "listen", config.FTP.Listen, "error", err)
+				os.Exit(1)
+			}
+		}()
+	}
+
 	// Start the scheduler
 	crn.Start()
 
-	// Keep the program running
-	select {}
+	// Keep the program running until interrupted, then wait for running jobs
+	<-ctx.Done()
+	logger.Info("Shutting down, waiting for running jobs")
+	if server != nil {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}
+	if ftpDone != nil {
+		<-ftpDone
+	}
+	<-crn.Stop().Done()
+}
+
+// discover prints the configuration of the cameras found on the network.
+func discover(cidr string, timeout time.Duration) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	logger.Info("Probing for ONVIF cameras", "timeout", timeout)
+	devices, err := discovery.Probe(ctx, timeout)
+	if err != nil {
+		return err
+	}
+	if cidr != "" {
+		logger.Info("Scanning for snapshot URLs", "network", cidr)
+		scanned, err := discovery.Scan(ctx, cidr)
+		if err != nil {
+			return err
+		}
+		// ONVIF devices are configured through discovery, not a fixed URL.
+		for _, d := range scanned {
+			if !slices.ContainsFunc(devices, func(o discovery.Device) bool { return o.Address == d.Address }) {
+				devices = append(devices, d)
+			}
+		}
+	}
+	logger.Info("Discovery finished", "cameras", len(devices))
+	if len(devices) == 0 {
+		return nil
+	}
+
+	out, err := config.MarshalCameras(discovery.CameraConfigs(devices))
+	if err != nil {
+		return err
+	}
+	fmt.Print(out)
+	return nil
 }